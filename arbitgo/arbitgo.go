@@ -1,12 +1,21 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/OopsMouse/arbitgo/models"
 
 	"github.com/OopsMouse/arbitgo/infrastructure"
 	"github.com/OopsMouse/arbitgo/usecase"
+	"github.com/OopsMouse/arbitgo/util"
 	log "github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 )
@@ -22,6 +31,25 @@ func main() {
 	var apiKey string
 	var secret string
 	var server string
+	var latency time.Duration
+	var queue bool
+	var aggressiveness float64
+	var maxLoss float64
+	var home string
+	var rebalance time.Duration
+	var drift float64
+	var balance string
+	var parallel bool
+	var timeInForce string
+	var quoteMaxAge time.Duration
+	var symbolMaxAge string
+	var maxOrder string
+	var maxSequence string
+	var maxConcurrent int
+	var maxDailyLoss string
+	var maxFailures int
+	var shutdownTimeout time.Duration
+	var journalPath string
 
 	app.Flags = []cli.Flag{
 		cli.BoolFlag{
@@ -51,39 +79,541 @@ func main() {
 			Usage:       "server host",
 			Destination: &server,
 		},
+		cli.DurationFlag{
+			Name:        "latency",
+			Usage:       "order entry latency simulated in dry run mode",
+			Destination: &latency,
+		},
+		cli.BoolFlag{
+			Name:        "queue",
+			Usage:       "simulate queue position in dry run mode",
+			Destination: &queue,
+		},
+		cli.Float64Flag{
+			Name:        "aggressiveness",
+			Usage:       "fraction the limit price crosses when unwinding stranded assets",
+			Value:       usecase.DefaultRecoveryConfig.Aggressiveness,
+			Destination: &aggressiveness,
+		},
+		cli.Float64Flag{
+			Name:        "max-loss",
+			Usage:       "largest fraction of value given up when unwinding stranded assets",
+			Value:       usecase.DefaultRecoveryConfig.MaxLoss,
+			Destination: &maxLoss,
+		},
+		cli.StringFlag{
+			Name:        "home",
+			Usage:       "home assets and their allocations such as BTC:60,ETH:30,BNB:10",
+			Destination: &home,
+		},
+		cli.DurationFlag{
+			Name:        "rebalance",
+			Usage:       "period to rebalance the home assets, 0 to disable",
+			Destination: &rebalance,
+		},
+		cli.Float64Flag{
+			Name:        "drift",
+			Usage:       "fraction of the total value a home asset may drift before it is rebalanced",
+			Value:       usecase.DefaultRebalanceConfig.Drift,
+			Destination: &drift,
+		},
+		cli.StringFlag{
+			Name:        "balance",
+			Usage:       "initial balances in dry run mode such as BTC:0.01,ETH:0.1",
+			Value:       "BTC:0.01",
+			Destination: &balance,
+		},
+		cli.BoolFlag{
+			Name:        "parallel",
+			Usage:       "send every leg at once when inventory of each leg's asset is held",
+			Destination: &parallel,
+		},
+		cli.StringFlag{
+			Name:        "time-in-force",
+			Usage:       "time in force of the orders of legs, GTC, IOC or FOK",
+			Value:       string(models.IOC),
+			Destination: &timeInForce,
+		},
+		cli.DurationFlag{
+			Name:        "quote-max-age",
+			Usage:       "age after which a quote is stale and not traded on",
+			Value:       util.DefaultDepthMaxAge,
+			Destination: &quoteMaxAge,
+		},
+		cli.StringFlag{
+			Name:        "symbol-max-age",
+			Usage:       "quote max age of some symbols such as ETHBTC:5s,XRPBTC:10s",
+			Destination: &symbolMaxAge,
+		},
+		cli.StringFlag{
+			Name:        "max-order",
+			Usage:       "largest notional of an order per quote asset such as BTC:0.1,ETH:1",
+			Destination: &maxOrder,
+		},
+		cli.StringFlag{
+			Name:        "max-sequence",
+			Usage:       "largest quantity a sequence starts with per asset such as BTC:0.1,ETH:1",
+			Destination: &maxSequence,
+		},
+		cli.IntFlag{
+			Name:        "max-concurrent",
+			Usage:       "largest number of sequences running at once, 0 for no limit",
+			Destination: &maxConcurrent,
+		},
+		cli.StringFlag{
+			Name:        "max-daily-loss",
+			Usage:       "realized loss per home asset and UTC day which kills the trader such as BTC:0.01",
+			Destination: &maxDailyLoss,
+		},
+		cli.IntFlag{
+			Name:        "max-failures",
+			Usage:       "consecutive failed legs which kill the trader, 0 for no limit",
+			Destination: &maxFailures,
+		},
+		cli.DurationFlag{
+			Name:        "shutdown-timeout",
+			Usage:       "time given to the sequences in flight to finish on shutdown before they are unwound",
+			Value:       usecase.DefaultShutdownTimeout,
+			Destination: &shutdownTimeout,
+		},
+		cli.StringFlag{
+			Name:        "journal",
+			Usage:       "file of the trade journal, which also settles what the previous run left open",
+			Destination: &journalPath,
+		},
 	}
 
 	app.Action = func(c *cli.Context) error {
 		if apiKey == "" || secret == "" {
 			return cli.NewExitError("api key and secret is required", 0)
 		}
+		logInit(debug)
+		sim := infrastructure.FillSimulation{
+			Latency:       latency,
+			QueuePosition: queue,
+		}
+		balances, err := parseBalances(balance)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		homeAssets, err := parseAssetValues(home)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		symbolMaxAges, err := parseDurations(symbolMaxAge)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		orderLimits, err := parseAssetValues(maxOrder)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		sequenceLimits, err := parseAssetValues(maxSequence)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		lossLimits, err := parseAssetValues(maxDailyLoss)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		tif := models.TimeInForce(strings.ToUpper(timeInForce))
+		if tif != models.GTC && tif != models.IOC && tif != models.FOK {
+			return cli.NewExitError("time in force must be GTC, IOC or FOK", 1)
+		}
+		exchange := newExchange(apiKey, secret, dryrun, balances, sim)
+		arbitrader := newTrader(exchange, &server)
+		arbitrader.HomeAssets = homeAssets
+		arbitrader.ParallelLegs = parallel
+		arbitrader.TimeInForce = tif
+		arbitrader.QuoteMaxAge = quoteMaxAge
+		arbitrader.SymbolMaxAge = symbolMaxAges
+		arbitrader.ShutdownTimeout = shutdownTimeout
+		arbitrader.Risk = usecase.RiskConfig{
+			MaxOrderNotional:       orderLimits,
+			MaxSequenceNotional:    sequenceLimits,
+			MaxConcurrent:          maxConcurrent,
+			MaxDailyLoss:           lossLimits,
+			MaxConsecutiveFailures: maxFailures,
+		}
+		arbitrader.Recovery = usecase.RecoveryConfig{
+			Aggressiveness: aggressiveness,
+			MaxLoss:        maxLoss,
+		}
+		arbitrader.Rebalancer = usecase.RebalanceConfig{
+			Interval: rebalance,
+			Drift:    drift,
+		}
+		if journalPath != "" {
+			journal, err := infrastructure.NewBoltJournal(journalPath)
+			if err != nil {
+				return cli.NewExitError(err.Error(), 1)
+			}
+			defer journal.Close()
+			arbitrader.Journal = journal
+		}
+		arbitrader.Run()
 		return nil
 	}
 
+	var recordDir string
+	var recordRotate time.Duration
+	var recordMaxSize int64
+
+	app.Commands = []cli.Command{
+		{
+			Name:  "record",
+			Usage: "record the depth feed to compressed files",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:        "dir",
+					Usage:       "directory to write records",
+					Value:       "./records",
+					Destination: &recordDir,
+				},
+				cli.DurationFlag{
+					Name:        "rotate",
+					Usage:       "period to start a new file",
+					Value:       1 * time.Hour,
+					Destination: &recordRotate,
+				},
+				cli.Int64Flag{
+					Name:        "max-size",
+					Usage:       "bytes to write before starting a new file",
+					Value:       256 * 1024 * 1024,
+					Destination: &recordMaxSize,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				logInit(debug)
+				var depch chan *models.Depth
+				var symbols []models.Symbol
+				if server != "" {
+					depch = usecase.DepthServerChannel(&server)
+				} else {
+					if apiKey == "" || secret == "" {
+						return cli.NewExitError("api key and secret is required", 0)
+					}
+					ex := newExchange(apiKey, secret, false, nil, infrastructure.FillSimulation{})
+					symbols = ex.GetSymbols()
+					depch = ex.GetDepthOnUpdate()
+				}
+				return record(depch, symbols, recordDir, recordRotate, recordMaxSize)
+			},
+		},
+	}
+
+	var backtestData string
+	var backtestFrom string
+	var backtestTo string
+	var backtestSpeed float64
+	var backtestBalance string
+	var backtestJSON string
+	var backtestTimeout time.Duration
+
+	app.Commands = append(app.Commands, cli.Command{
+		Name:  "backtest",
+		Usage: "run the trader against recorded depthes and report the result",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:        "data",
+				Usage:       "directory or file of records",
+				Value:       "./records",
+				Destination: &backtestData,
+			},
+			cli.StringFlag{
+				Name:        "from",
+				Usage:       "replay records from this time (RFC3339 or 2006-01-02 15:04:05)",
+				Destination: &backtestFrom,
+			},
+			cli.StringFlag{
+				Name:        "to",
+				Usage:       "replay records until this time (RFC3339 or 2006-01-02 15:04:05)",
+				Destination: &backtestTo,
+			},
+			cli.Float64Flag{
+				Name:        "speed",
+				Usage:       "replay speed, 1 is real time and 0 is as fast as possible",
+				Destination: &backtestSpeed,
+			},
+			cli.StringFlag{
+				Name:        "balance",
+				Usage:       "initial balances such as BTC:0.01,ETH:0.1",
+				Value:       "BTC:0.01",
+				Destination: &backtestBalance,
+			},
+			cli.StringFlag{
+				Name:        "json",
+				Usage:       "write the report as JSON to this file, - for stdout",
+				Destination: &backtestJSON,
+			},
+			cli.DurationFlag{
+				Name:        "timeout",
+				Usage:       "time to wait for the fill of an order before giving up",
+				Value:       time.Second,
+				Destination: &backtestTimeout,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			logInit(debug)
+			sim := infrastructure.FillSimulation{
+				Latency:       latency,
+				QueuePosition: queue,
+			}
+			homeAssets, err := parseAssetValues(home)
+			if err != nil {
+				return cli.NewExitError(err.Error(), 1)
+			}
+			recovery := usecase.RecoveryConfig{
+				Aggressiveness: aggressiveness,
+				MaxLoss:        maxLoss,
+			}
+			return backtest(backtestData, backtestFrom, backtestTo, backtestSpeed, backtestBalance, backtestJSON, backtestTimeout, homeAssets, recovery, sim)
+		},
+	})
+
+	var journalFile string
+	var journalFrom string
+	var journalTo string
+	var journalJSON bool
+
+	app.Commands = append(app.Commands, cli.Command{
+		Name:  "journal",
+		Usage: "show the sequences of the trade journal and their realized PnL",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:        "file",
+				Usage:       "file of the trade journal",
+				Value:       "./journal.db",
+				Destination: &journalFile,
+			},
+			cli.StringFlag{
+				Name:        "from",
+				Usage:       "show sequences from this time (RFC3339 or 2006-01-02 15:04:05)",
+				Destination: &journalFrom,
+			},
+			cli.StringFlag{
+				Name:        "to",
+				Usage:       "show sequences until this time (RFC3339 or 2006-01-02 15:04:05)",
+				Destination: &journalTo,
+			},
+			cli.BoolFlag{
+				Name:        "json",
+				Usage:       "print the entries of the sequences as JSON",
+				Destination: &journalJSON,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			return showJournal(journalFile, journalFrom, journalTo, journalJSON)
+		},
+	})
+
 	app.Run(os.Args)
+}
+
+func showJournal(path string, from string, to string, asJSON bool) error {
+	fromTime, err := parseTime(from)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	toTime, err := parseTime(to)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	journal, err := infrastructure.NewBoltJournal(path)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	defer journal.Close()
+
+	sequences, err := journal.Sequences(fromTime, toTime)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	if asJSON {
+		bytes, err := json.MarshalIndent(sequences, "", "  ")
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		fmt.Println(string(bytes))
+		return nil
+	}
+
+	total := map[string]float64{}
+	for _, seq := range sequences {
+		route := []string{}
+		orders := map[string]bool{}
+		for _, entry := range seq.Entries {
+			switch entry.Kind {
+			case models.JournalSequence:
+				for _, leg := range entry.Legs {
+					route = append(route, leg.From+">"+leg.To)
+				}
+			case models.JournalOrder:
+				orders[entry.Order.ID] = true
+			}
+		}
+		pnl := seq.PnL()
+		for asset, value := range pnl {
+			total[asset] += value
+		}
+		fmt.Printf("%s %s %s orders:%d pnl:%v\n", seq.Entries[0].Time.Format("2006-01-02 15:04:05"), seq.ID, strings.Join(route, ","), len(orders), pnl)
+	}
+	fmt.Printf("sequences:%d realized pnl:%v\n", len(sequences), total)
+	return nil
+}
+
+func backtest(data string, from string, to string, speed float64, balance string, jsonFile string, timeout time.Duration, homeAssets map[string]float64, recovery usecase.RecoveryConfig, sim infrastructure.FillSimulation) error {
+	fromTime, err := parseTime(from)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	toTime, err := parseTime(to)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	balances, err := parseBalances(balance)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	replay, err := infrastructure.NewReplayExchange(data, speed, fromTime, toTime)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	exchange := infrastructure.NewExchangeStubWithSimulation(replay, balances, sim)
+	trader := newTrader(exchange, nil)
+	trader.ConfirmTimeout = timeout
+	trader.Clock = replay.Now
+	trader.HomeAssets = homeAssets
+	trader.Recovery = recovery
+	report := trader.Backtest()
+	report.Print()
+
+	if jsonFile == "" {
+		return nil
+	}
+	bytes, err := report.JSON()
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	if jsonFile == "-" {
+		fmt.Println(string(bytes))
+		return nil
+	}
+	err = ioutil.WriteFile(jsonFile, bytes, 0644)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	return nil
+}
+
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"} {
+		t, err := time.ParseInLocation(layout, s, time.Local)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("Invalid time: %s", s)
+}
+
+func parseBalances(s string) (map[string]*models.Balance, error) {
+	values, err := parseAssetValues(s)
+	if err != nil {
+		return nil, err
+	}
+	balances := map[string]*models.Balance{}
+	for asset, qty := range values {
+		balances[asset] = &models.Balance{
+			Asset: asset,
+			Free:  qty,
+			Total: qty,
+		}
+	}
+	return balances, nil
+}
+
+// parseAssetValues parses a list such as BTC:0.6,ETH:0.3.
+func parseAssetValues(s string) (map[string]float64, error) {
+	values := map[string]float64{}
+	for _, item := range strings.Split(s, ",") {
+		if item == "" {
+			continue
+		}
+		kv := strings.SplitN(item, ":", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("Invalid asset value: %s", item)
+		}
+		value, err := strconv.ParseFloat(kv[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid asset value: %s", item)
+		}
+		values[kv[0]] = value
+	}
+	return values, nil
+}
+
+// parseDurations parses a list such as ETHBTC:5s,XRPBTC:10s.
+func parseDurations(s string) (map[string]time.Duration, error) {
+	durations := map[string]time.Duration{}
+	for _, item := range strings.Split(s, ",") {
+		if item == "" {
+			continue
+		}
+		kv := strings.SplitN(item, ":", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("Invalid duration: %s", item)
+		}
+		d, err := time.ParseDuration(kv[1])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid duration: %s", item)
+		}
+		durations[kv[0]] = d
+	}
+	return durations, nil
+}
 
-	logInit(debug)
-	exchange := newExchange(apiKey, secret, dryrun)
-	arbitrader := newTrader(exchange, &server)
-	arbitrader.Run()
+func record(depch chan *models.Depth, symbols []models.Symbol, dir string, rotate time.Duration, maxSize int64) error {
+	recorder, err := infrastructure.NewDepthRecorder(dir, rotate, maxSize, symbols)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	errch := make(chan error, 1)
+	go func() {
+		errch <- recorder.Record(depch)
+	}()
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	select {
+	case kill := <-interrupt:
+		log.Info("Got signal : ", kill)
+		log.Info("Stopping recorder")
+		return recorder.Close()
+	case err := <-errch:
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		return nil
+	}
 }
 
-func newExchange(apikey string, secret string, dryRun bool) usecase.Exchange {
+func newExchange(apikey string, secret string, dryRun bool, balances map[string]*models.Balance, sim infrastructure.FillSimulation) usecase.Exchange {
 	binance := infrastructure.NewBinance(
 		apikey,
 		secret,
 	)
 
 	if dryRun {
-		balances := map[string]*models.Balance{}
-		balances["BTC"] = &models.Balance{
-			Asset: "BTC",
-			Free:  0.01,
-			Total: 0.01,
-		}
-		return infrastructure.NewExchangeStub(
+		return infrastructure.NewExchangeStubWithSimulation(
 			binance,
 			balances,
+			sim,
 		)
 	}
 
@@ -4,7 +4,6 @@ import (
 	"context"
 	"os"
 	"strconv"
-	"sync"
 	"time"
 
 	models "github.com/OopsMouse/arbitgo/models"
@@ -20,9 +19,12 @@ type Binance struct {
 	QuoteAssetSet *util.Set
 	Symbols       []models.Symbol
 	DepthCache    cmap.ConcurrentMap
+	Streams       cmap.ConcurrentMap
 	UseWebsocket  bool
 }
 
+const binanceURL = "https://www.binance.com"
+
 func NewBinance(apikey string, secret string) Binance {
 	var logger log.Logger
 	logger = log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr))
@@ -33,14 +35,16 @@ func NewBinance(apikey string, secret string) Binance {
 	}
 	ctx, _ := context.WithCancel(context.Background())
 	binanceService := binance.NewAPIService(
-		"https://www.binance.com",
+		binanceURL,
 		apikey,
 		hmacSigner,
 		logger,
 		ctx,
 	)
 
-	b := binance.NewBinance(binanceService)
+	limiter := NewRateLimiter("binance", binanceWeightPerMinute, binanceOrdersPerSecond, binanceOrdersPerDay)
+	b := newLimitedBinance(binance.NewBinance(binanceService), limiter, binanceURL)
+	go b.syncWeight()
 
 	var exInfo *binance.ExchangeInfo
 	err := util.BackoffRetry(5, func() error {
@@ -119,11 +123,16 @@ func NewBinance(apikey string, secret string) Binance {
 		QuoteAssetSet: quoteAssetSet,
 		Symbols:       symbols,
 		DepthCache:    cmap.New(),
+		Streams:       cmap.New(),
 		UseWebsocket:  true,
 	}
 	return ex
 }
 
+func (bi Binance) Name() string {
+	return "binance"
+}
+
 func (bi Binance) GetFee() float64 {
 	return 0.001
 }
@@ -165,7 +174,7 @@ func (bi Binance) GetSymbols() []models.Symbol {
 func (bi Binance) GetDepth(symbol models.Symbol) (*models.Depth, error) {
 	request := binance.OrderBookRequest{
 		Symbol: symbol.String(),
-		Limit:  5,
+		Limit:  20,
 	}
 	book, err := bi.Api.OrderBook(request)
 	if err != nil {
@@ -184,206 +193,46 @@ func getDepthInOrderBook(symbol models.Symbol, orderBook *binance.OrderBook) (*m
 		return nil, errors.Errorf("Bids or Asks length is empty")
 	}
 
-	quoteAsset := symbol.QuoteAsset
-	baseAsset := symbol.BaseAsset
-	bidPrice := orderBook.Bids[0].Price
-	bidQty := orderBook.Bids[0].Quantity
-	for i := 1; i < len(orderBook.Bids); i++ {
-		if orderBook.Bids[i].Price == bidPrice {
-			bidQty += orderBook.Bids[i].Quantity
-		} else {
-			break
-		}
-	}
-	askPrice := orderBook.Asks[0].Price
-	askQty := orderBook.Asks[0].Quantity
-	for i := 1; i < len(orderBook.Asks); i++ {
-		if orderBook.Asks[i].Price == askPrice {
-			askQty += orderBook.Asks[i].Quantity
-		} else {
-			break
-		}
+	now := time.Now()
+	book := &models.OrderBook{
+		Symbol: symbol,
+		Bids:   getOrderBookLevels(orderBook.Bids),
+		Asks:   getOrderBookLevels(orderBook.Asks),
+		Time:   now,
 	}
+
 	return &models.Depth{
 		Symbol:     symbol,
-		BaseAsset:  baseAsset,
-		QuoteAsset: quoteAsset,
-		BidPrice:   bidPrice,
-		AskPrice:   askPrice,
-		BidQty:     bidQty,
-		AskQty:     askQty,
-		Time:       time.Now(),
+		BaseAsset:  symbol.BaseAsset,
+		QuoteAsset: symbol.QuoteAsset,
+		BidPrice:   book.Bids[0].Price,
+		AskPrice:   book.Asks[0].Price,
+		BidQty:     book.Bids[0].Quantity,
+		AskQty:     book.Asks[0].Quantity,
+		Time:       now,
+		OrderBook:  book,
 	}, nil
 }
 
-func (bi Binance) connectWebsocket(symbol models.Symbol) (chan *binance.OrderBook, chan struct{}, error) {
-	var obch chan *binance.OrderBook
-	var done chan struct{}
-	req := binance.OrderBookRequest{
-		Symbol: symbol.String(),
-		Level:  20,
-	}
-	err := util.BackoffRetry(5, func() error {
-		r, d, err := bi.Api.OrderBookWebsocket(req)
-		obch = r
-		done = d
-		return err
-	})
-	return obch, done, err
-}
-
-func (bi Binance) getDepthOnUpdateWebsocket(symbols []models.Symbol) (chan *models.Depth, chan bool) {
-	m := new(sync.Mutex)
-	stopping := false
-	dch := make(chan *models.Depth)
-	websockClose := make(chan struct{})
-
-	for _, symbol := range symbols {
-		go func(symbol models.Symbol) {
-			for {
-				for stopping {
-				}
-
-				go func(symbol models.Symbol) {
-					defer func() {
-						websockClose <- struct{}{}
-					}()
-
-					if stopping {
-						return
-					}
-
-					obch, done, err := bi.connectWebsocket(symbol)
-
-					if err != nil {
-						return
-					}
-
-					for {
-						if stopping {
-							return
-						}
-
-						select {
-						case orderbook := <-obch:
-							depth, err := getDepthInOrderBook(
-								symbol,
-								orderbook,
-							)
-							if err != nil {
-								continue
-							}
-							dch <- depth
-						case <-done:
-							return
-						default:
-						}
-					}
-				}(symbol)
-				<-websockClose
-			}
-		}(symbol)
-	}
-
-	stopch := make(chan bool)
-
-	go func() {
-		defer close(stopch)
-		for {
-			s := <-stopch
-			m.Lock()
-			stopping = s
-			m.Unlock()
-		}
-	}()
-
-	return dch, stopch
-}
-
-func (bi Binance) getDepthOnUpdateRequest(symbols []models.Symbol) (chan *models.Depth, chan bool) {
-	m := new(sync.Mutex)
-	stopping := false
-	stopch := make(chan bool)
-	dch := make(chan *models.Depth, len(symbols))
-
-	depthReqChan := make(chan models.Symbol)
-
-	go func() {
-		for {
-			if stopping {
-				continue
-			}
-
-			select {
-			case symbol := <-depthReqChan:
-				depth, err := bi.GetDepth(symbol)
-				time.Sleep(1 / 1200 * time.Minute)
-				if err != nil {
-					continue
-				}
-				dch <- depth
-			}
-		}
-	}()
-
-	go func() {
-		for {
-			for _, symbol := range symbols {
-				depthReqChan <- symbol
-			}
-		}
-	}()
-
-	go func() {
-		defer close(stopch)
-		for {
-			s := <-stopch
-			m.Lock()
-			stopping = s
-			m.Unlock()
-		}
-	}()
-
-	return dch, stopch
-}
-
-func (bi Binance) getQuoteToQuotePairSymbols(symbols []models.Symbol) []models.Symbol {
-	ret := []models.Symbol{}
-	for _, s := range symbols {
-		if bi.QuoteAssetSet.Include(s.BaseAsset) &&
-			bi.QuoteAssetSet.Include(s.QuoteAsset) {
-			ret = append(ret, s)
-		}
-	}
-	return ret
-}
-
-func (bi Binance) getQuoteToBasePairSymbols(symbols []models.Symbol) []models.Symbol {
-	ret := []models.Symbol{}
-	for _, s := range symbols {
-		if !bi.QuoteAssetSet.Include(s.BaseAsset) {
-			ret = append(ret, s)
+func getOrderBookLevels(orders []*binance.Order) []models.OrderBookLevel {
+	levels := []models.OrderBookLevel{}
+	for _, o := range orders {
+		if len(levels) > 0 && levels[len(levels)-1].Price == o.Price {
+			levels[len(levels)-1].Quantity += o.Quantity
+			continue
 		}
+		levels = append(levels, models.OrderBookLevel{
+			Price:    o.Price,
+			Quantity: o.Quantity,
+		})
 	}
-	return ret
+	return levels
 }
 
+// GetDepthOnUpdate delivers the best levels of every symbol from its local
+// order book.
 func (bi Binance) GetDepthOnUpdate() chan *models.Depth {
-	dch := make(chan *models.Depth)
-	symbols := bi.GetSymbols()
-	r, _ := bi.getDepthOnUpdateRequest(bi.getQuoteToQuotePairSymbols(symbols))
-	w, _ := bi.getDepthOnUpdateWebsocket(bi.getQuoteToBasePairSymbols(symbols))
-	go func() {
-		for {
-			select {
-			case d := <-r:
-				dch <- d
-			case d := <-w:
-				dch <- d
-			}
-		}
-	}()
-	return dch
+	return bi.getDepthOnUpdateWebsocket(bi.GetSymbols())
 }
 
 func (bi Binance) SendOrder(order *models.Order) error {
@@ -398,7 +247,7 @@ func (bi Binance) SendOrder(order *models.Order) error {
 		nor = binance.NewOrderRequest{
 			Symbol:           order.Symbol.String(),
 			Type:             binance.TypeLimit,
-			TimeInForce:      binance.GTC,
+			TimeInForce:      timeInForceOf(order),
 			Side:             side,
 			Quantity:         order.Quantity,
 			Price:            order.Price,
@@ -415,45 +264,97 @@ func (bi Binance) SendOrder(order *models.Order) error {
 			Timestamp:        time.Now(),
 		}
 	}
+	// The client order ID makes the submission idempotent. When it is unknown
+	// whether the exchange accepted the order, it is queried before sending it
+	// again, since a second order would trade the leg twice.
+	var po *binance.ProcessedOrder
+	found := false
+	unsure := false
 	err := util.BackoffRetry(5, func() error {
-		return bi.Api.NewOrderTest(nor)
+		if unsure {
+			_, err := bi.queryOrder(order)
+			if models.KindOf(err) != models.ErrUnknownOrder {
+				found = err == nil
+				return err
+			}
+			unsure = false
+		}
+		o, err := bi.Api.NewOrder(nor)
+		if models.KindOf(err) == models.ErrDuplicateOrder {
+			_, err = bi.queryOrder(order)
+			found = err == nil
+			return err
+		}
+		if err != nil {
+			unsure = models.IsRetryable(err)
+			return err
+		}
+		po = o
+		return nil
 	})
 	if err != nil {
 		return err
 	}
-	var po *binance.ProcessedOrder
-	err = util.BackoffRetry(5, func() error {
-		p, err := bi.Api.NewOrder(nor)
-		po = p
-		return err
-	})
-	if err != nil {
-		return err
+	if found {
+		return nil
 	}
-	return nil
+	order.ExchangeOrderID = po.OrderID
+	return order.Transit(models.StatusNew, po.TransactTime)
 }
 
-func (bi Binance) ConfirmOrder(order *models.Order) (float64, error) {
-	oor := binance.OpenOrdersRequest{
-		Symbol:     order.Symbol.String(),
-		RecvWindow: 10 * time.Second,
-		Timestamp:  time.Now(),
+func timeInForceOf(order *models.Order) binance.TimeInForce {
+	if order.TimeInForce == "" {
+		return binance.GTC
 	}
-	var openOrders []*binance.ExecutedOrder
+	return binance.TimeInForce(order.TimeInForce)
+}
+
+// ConfirmOrder queries the order itself rather than the open orders, since an
+// IOC or FOK order which expired is never open.
+func (bi Binance) ConfirmOrder(order *models.Order) (float64, error) {
+	var event *models.OrderEvent
 	err := util.BackoffRetry(5, func() error {
-		oo, err := bi.Api.OpenOrders(oor)
-		openOrders = oo
+		e, err := bi.queryOrder(order)
+		event = e
 		return err
 	})
 	if err != nil {
 		return 0, err
 	}
-	for _, o := range openOrders {
-		if o.ClientOrderID == order.ID {
-			return o.ExecutedQty, nil
-		}
+	return event.ExecutedQty, nil
+}
+
+// QueryOrder updates the order with its state on the exchange, looked up by
+// the client order ID. The error is of ErrUnknownOrder when the exchange has
+// never received it.
+func (bi Binance) QueryOrder(order *models.Order) error {
+	return util.BackoffRetry(5, func() error {
+		_, err := bi.queryOrder(order)
+		return err
+	})
+}
+
+func (bi Binance) queryOrder(order *models.Order) (*models.OrderEvent, error) {
+	qor := binance.QueryOrderRequest{
+		Symbol:            order.Symbol.String(),
+		OrigClientOrderID: order.ID,
+		RecvWindow:        10 * time.Second,
+		Timestamp:         time.Now(),
+	}
+	executedOrder, err := bi.Api.QueryOrder(qor)
+	if err != nil {
+		return nil, err
+	}
+	event := &models.OrderEvent{
+		Status:          models.OrderStatus(executedOrder.Status),
+		OrderID:         order.ID,
+		ExchangeOrderID: int64(executedOrder.OrderID),
+		ExecutedQty:     executedOrder.ExecutedQty,
+		Time:            time.Now(),
 	}
-	return order.Quantity, nil
+	// A stale local status must not hide the executed quantity.
+	order.Apply(event)
+	return event, nil
 }
 
 func (bi Binance) CancelOrder(order *models.Order) error {
@@ -470,5 +371,5 @@ func (bi Binance) CancelOrder(order *models.Order) error {
 	if err != nil {
 		return err
 	}
-	return nil
+	return order.Transit(models.StatusPendingCancel, time.Now())
 }
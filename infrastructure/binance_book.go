@@ -0,0 +1,306 @@
+package infrastructure
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	models "github.com/OopsMouse/arbitgo/models"
+	"github.com/OopsMouse/arbitgo/util"
+	binance "github.com/OopsMouse/go-binance"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// A snapshot of 100 levels weighs 1 against 10 for 1000, and still covers
+// twice the levels delivered. Only a few are fetched at once, since every book
+// resyncs after a reconnect.
+const (
+	bookSnapshotLimit       = 100
+	bookSnapshotConcurrency = 2
+	bookDepthLevels         = 50
+	bookResyncInterval      = 1 * time.Second
+)
+
+var errBookGap = errors.New("Gap in depth updates")
+
+type depthUpdate struct {
+	Event         string          `json:"e"`
+	EventTime     int64           `json:"E"`
+	Symbol        string          `json:"s"`
+	FirstUpdateID int64           `json:"U"`
+	FinalUpdateID int64           `json:"u"`
+	Bids          [][]interface{} `json:"b"`
+	Asks          [][]interface{} `json:"a"`
+}
+
+// localBook maintains the full order book of a symbol from a REST snapshot
+// and the diff-depth updates which follow it. Updates arriving before the
+// snapshot are buffered and replayed on top of it.
+type localBook struct {
+	symbol       models.Symbol
+	bids         *bookSide
+	asks         *bookSide
+	lastUpdateID int64
+	synced       bool
+	syncing      bool
+	buffer       []*depthUpdate
+	snapshots    chan struct{}
+	lock         *sync.Mutex
+}
+
+func newLocalBook(symbol models.Symbol) *localBook {
+	return &localBook{
+		symbol: symbol,
+		bids:   newBookSide(true),
+		asks:   newBookSide(false),
+		lock:   new(sync.Mutex),
+	}
+}
+
+// bookSide keeps the levels of one side sorted best first, so that the top
+// levels are read without sorting the side on every update.
+type bookSide struct {
+	prices     []float64
+	quantities map[float64]float64
+	descending bool
+}
+
+func newBookSide(descending bool) *bookSide {
+	return &bookSide{
+		quantities: map[float64]float64{},
+		descending: descending,
+	}
+}
+
+// index returns where the price is or would be inserted.
+func (bs *bookSide) index(price float64) int {
+	return sort.Search(len(bs.prices), func(i int) bool {
+		if bs.descending {
+			return bs.prices[i] <= price
+		}
+		return bs.prices[i] >= price
+	})
+}
+
+// set updates the level at the price, removing it when the quantity is 0.
+func (bs *bookSide) set(price float64, quantity float64) {
+	_, ok := bs.quantities[price]
+	if quantity == 0 {
+		if ok {
+			i := bs.index(price)
+			bs.prices = append(bs.prices[:i], bs.prices[i+1:]...)
+			delete(bs.quantities, price)
+		}
+		return
+	}
+	if !ok {
+		i := bs.index(price)
+		bs.prices = append(bs.prices, 0)
+		copy(bs.prices[i+1:], bs.prices[i:])
+		bs.prices[i] = price
+	}
+	bs.quantities[price] = quantity
+}
+
+func (bs *bookSide) top(levels int) []*binance.Order {
+	if len(bs.prices) < levels {
+		levels = len(bs.prices)
+	}
+	orders := make([]*binance.Order, 0, levels)
+	for _, price := range bs.prices[:levels] {
+		orders = append(orders, &binance.Order{Price: price, Quantity: bs.quantities[price]})
+	}
+	return orders
+}
+
+// reset drops the book until the next snapshot. The caller holds lock.
+func (lb *localBook) reset() {
+	lb.bids = newBookSide(true)
+	lb.asks = newBookSide(false)
+	lb.lastUpdateID = 0
+	lb.synced = false
+	lb.buffer = nil
+}
+
+// load replaces the book with the snapshot and replays the buffered updates.
+// A gap means the snapshot is older than the first buffered update, which is
+// kept for the next snapshot. The caller holds lock.
+func (lb *localBook) load(snapshot *binance.OrderBook) error {
+	lb.bids = newBookSide(true)
+	lb.asks = newBookSide(false)
+	for _, o := range snapshot.Bids {
+		lb.bids.set(o.Price, o.Quantity)
+	}
+	for _, o := range snapshot.Asks {
+		lb.asks.set(o.Price, o.Quantity)
+	}
+	lb.lastUpdateID = int64(snapshot.LastUpdateID)
+
+	for _, u := range lb.buffer {
+		if err := lb.apply(u); err != nil {
+			return err
+		}
+	}
+	lb.buffer = nil
+	lb.synced = true
+	return nil
+}
+
+// apply applies the update unless it is already in the book. Every update
+// must start right after the last one applied. The caller holds lock.
+func (lb *localBook) apply(u *depthUpdate) error {
+	if u.FinalUpdateID <= lb.lastUpdateID {
+		return nil
+	}
+	if u.FirstUpdateID > lb.lastUpdateID+1 {
+		return errBookGap
+	}
+	applyLevels(lb.bids, u.Bids)
+	applyLevels(lb.asks, u.Asks)
+	lb.lastUpdateID = u.FinalUpdateID
+	return nil
+}
+
+func applyLevels(side *bookSide, levels [][]interface{}) {
+	for _, o := range parseBookLevels(levels) {
+		side.set(o.Price, o.Quantity)
+	}
+}
+
+// orderBook returns the best levels of the book. The caller holds lock.
+func (lb *localBook) orderBook(levels int) *binance.OrderBook {
+	return &binance.OrderBook{
+		LastUpdateID: int(lb.lastUpdateID),
+		Bids:         lb.bids.top(levels),
+		Asks:         lb.asks.top(levels),
+	}
+}
+
+func diffDepthStream(symbol models.Symbol) string {
+	return strings.ToLower(symbol.String()) + "@depth"
+}
+
+// syncBook loads a snapshot into the book, fetching a newer one for as long
+// as the snapshot is older than the buffered updates.
+func (bi Binance) syncBook(book *localBook) {
+	for {
+		var snapshot *binance.OrderBook
+		if book.snapshots != nil {
+			book.snapshots <- struct{}{}
+		}
+		err := util.BackoffRetry(5, func() error {
+			s, err := bi.Api.OrderBook(binance.OrderBookRequest{
+				Symbol: book.symbol.String(),
+				Limit:  bookSnapshotLimit,
+			})
+			snapshot = s
+			return err
+		})
+		if book.snapshots != nil {
+			<-book.snapshots
+		}
+
+		book.lock.Lock()
+		if err != nil {
+			log.Error("Failed to get order book of ", book.symbol, " : ", err)
+			book.syncing = false
+			book.lock.Unlock()
+			return
+		}
+		err = book.load(snapshot)
+		if err == nil {
+			book.syncing = false
+			book.lock.Unlock()
+			return
+		}
+		log.Debug("Resync order book of ", book.symbol, " : ", err)
+		book.lock.Unlock()
+		time.Sleep(bookResyncInterval)
+	}
+}
+
+// onDepthUpdate applies the update to the book and returns its depth, or nil
+// while the book is not synced. A gap resyncs the book.
+func (bi Binance) onDepthUpdate(book *localBook, u *depthUpdate) *models.Depth {
+	defer book.lock.Unlock()
+	book.lock.Lock()
+
+	if book.synced {
+		err := book.apply(u)
+		if err == nil {
+			depth, err := getDepthInOrderBook(book.symbol, book.orderBook(bookDepthLevels))
+			if err != nil {
+				return nil
+			}
+			depth.EventTime = time.Unix(0, u.EventTime*int64(time.Millisecond))
+			depth.UpdateID = u.FinalUpdateID
+			return depth
+		}
+		log.Warn("Resync order book of ", book.symbol, " : ", err)
+		book.reset()
+	}
+
+	book.buffer = append(book.buffer, u)
+	if !book.syncing {
+		book.syncing = true
+		go bi.syncBook(book)
+	}
+	return nil
+}
+
+// getDepthOnUpdateWebsocket maintains a local order book of every symbol from
+// the diff-depth streams, multiplexed over as few combined-stream connections
+// as possible, and delivers its best levels on every update.
+func (bi Binance) getDepthOnUpdateWebsocket(symbols []models.Symbol) chan *models.Depth {
+	dch := make(chan *models.Depth, len(symbols))
+
+	books := map[string]*localBook{}
+	streams := []string{}
+	snapshots := make(chan struct{}, bookSnapshotConcurrency)
+	for _, symbol := range symbols {
+		stream := diffDepthStream(symbol)
+		books[stream] = newLocalBook(symbol)
+		books[stream].snapshots = snapshots
+		streams = append(streams, stream)
+	}
+
+	handle := func(stream string, data json.RawMessage) {
+		book, ok := books[stream]
+		if !ok {
+			return
+		}
+		var u depthUpdate
+		if err := json.Unmarshal(data, &u); err != nil {
+			log.Debug("Failed to parse depth update of ", stream, " : ", err)
+			return
+		}
+		if depth := bi.onDepthUpdate(book, &u); depth != nil {
+			dch <- depth
+		}
+	}
+
+	for _, chunk := range chunkStreams(streams, maxStreamsPerConnection) {
+		chunk := chunk
+		cs := &combinedStream{
+			url:     binanceStreamURL,
+			streams: chunk,
+			health:  bi.Streams,
+			handle:  handle,
+			// Updates were missed while disconnected.
+			connected: func() {
+				for _, stream := range chunk {
+					book := books[stream]
+					book.lock.Lock()
+					book.reset()
+					book.lock.Unlock()
+				}
+			},
+		}
+		go cs.run()
+	}
+
+	return dch
+}
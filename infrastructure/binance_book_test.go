@@ -0,0 +1,82 @@
+package infrastructure
+
+import (
+	"testing"
+
+	models "github.com/OopsMouse/arbitgo/models"
+	binance "github.com/OopsMouse/go-binance"
+)
+
+func level(price string, quantity string) []interface{} {
+	return []interface{}{price, quantity, []interface{}{}}
+}
+
+func TestLocalBookSync(t *testing.T) {
+	book := newLocalBook(models.Symbol{Text: "ETHBTC", BaseAsset: "ETH", QuoteAsset: "BTC"})
+
+	book.buffer = []*depthUpdate{
+		{FirstUpdateID: 95, FinalUpdateID: 100, Bids: [][]interface{}{level("0.08", "9")}},
+		{FirstUpdateID: 101, FinalUpdateID: 103, Bids: [][]interface{}{level("0.09", "0")}, Asks: [][]interface{}{level("0.092", "3")}},
+	}
+	snapshot := &binance.OrderBook{
+		LastUpdateID: 101,
+		Bids:         []*binance.Order{{Price: 0.09, Quantity: 1}, {Price: 0.089, Quantity: 2}},
+		Asks:         []*binance.Order{{Price: 0.091, Quantity: 1}},
+	}
+	if err := book.load(snapshot); err != nil || !book.synced {
+		t.Fatal("test failed")
+	}
+
+	ob := book.orderBook(10)
+	if len(ob.Bids) != 1 || ob.Bids[0].Price != 0.089 || len(ob.Asks) != 2 || ob.Asks[1].Price != 0.092 {
+		t.Fatal("test failed")
+	}
+	if book.lastUpdateID != 103 {
+		t.Fatal("test failed")
+	}
+
+	if book.apply(&depthUpdate{FirstUpdateID: 104, FinalUpdateID: 104, Asks: [][]interface{}{level("0.0905", "1")}}) != nil {
+		t.Fatal("test failed")
+	}
+	if book.orderBook(1).Asks[0].Price != 0.0905 {
+		t.Fatal("test failed")
+	}
+
+	if book.apply(&depthUpdate{FirstUpdateID: 106, FinalUpdateID: 107}) != errBookGap {
+		t.Fatal("test failed")
+	}
+}
+
+func TestLocalBookStaleSnapshot(t *testing.T) {
+	book := newLocalBook(models.Symbol{Text: "ETHBTC"})
+	book.buffer = []*depthUpdate{
+		{FirstUpdateID: 110, FinalUpdateID: 112},
+	}
+	if book.load(&binance.OrderBook{LastUpdateID: 100}) != errBookGap {
+		t.Fatal("test failed")
+	}
+	if book.synced || len(book.buffer) != 1 {
+		t.Fatal("test failed")
+	}
+	if book.load(&binance.OrderBook{LastUpdateID: 111}) != nil || book.lastUpdateID != 112 {
+		t.Fatal("test failed")
+	}
+}
+
+func TestBookSideSorted(t *testing.T) {
+	side := newBookSide(true)
+	for _, price := range []float64{0.5, 0.7, 0.6, 0.4} {
+		side.set(price, 1)
+	}
+	side.set(0.6, 2)
+	side.set(0.7, 0)
+	side.set(0.3, 0)
+
+	top := side.top(10)
+	if len(top) != 3 || top[0].Price != 0.6 || top[0].Quantity != 2 || top[1].Price != 0.5 || top[2].Price != 0.4 {
+		t.Fatal("test failed")
+	}
+	if len(side.top(1)) != 1 {
+		t.Fatal("test failed")
+	}
+}
@@ -0,0 +1,84 @@
+package infrastructure
+
+import (
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	models "github.com/OopsMouse/arbitgo/models"
+	"github.com/pkg/errors"
+)
+
+var binanceErrorCodePattern = regexp.MustCompile(`-[12]\d{3}`)
+
+// binanceErrorKinds maps the codes of the API to their kind. Any other code is
+// a rejection of the request.
+var binanceErrorKinds = map[int]models.ErrorKind{
+	-1000: models.ErrUnknown, // unknown error
+	-1001: models.ErrNetwork, // disconnected
+	-1002: models.ErrAuth,    // unauthorized
+	-1006: models.ErrNetwork, // unexpected response, status unknown
+	-1007: models.ErrNetwork, // timeout
+	-1013: models.ErrFilter,  // filter failure
+	-1021: models.ErrNetwork, // timestamp outside of the recvWindow
+	-1022: models.ErrAuth,    // invalid signature
+	-1100: models.ErrFilter,  // illegal characters
+	-1111: models.ErrFilter,  // too much precision
+	-1112: models.ErrFilter,  // no depth
+	-1115: models.ErrFilter,  // invalid time in force
+	-1116: models.ErrFilter,  // invalid order type
+	-1117: models.ErrFilter,  // invalid side
+	-1121: models.ErrFilter,  // invalid symbol
+	-2011: models.ErrUnknownOrder,
+	-2013: models.ErrUnknownOrder,
+	-2014: models.ErrAuth, // bad API key format
+	-2015: models.ErrAuth, // rejected API key, IP or permissions
+}
+
+// classifyBinanceError turns an error of the API into an ExchangeError of its
+// kind. Rate limit errors and errors of no known kind and no code are returned
+// as they are.
+func classifyBinanceError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := models.AsRateLimitError(err); ok {
+		return err
+	}
+
+	message := err.Error()
+	kind := models.ErrUnknown
+	code := 0
+	if m := binanceErrorCodePattern.FindString(message); m != "" {
+		code, _ = strconv.Atoi(m)
+		kind = models.ErrRejected
+		if k, ok := binanceErrorKinds[code]; ok {
+			kind = k
+		}
+	}
+
+	cause := errors.Cause(err)
+	if _, ok := cause.(net.Error); ok || cause == io.EOF || cause == io.ErrUnexpectedEOF {
+		kind = models.ErrNetwork
+	}
+	// -2010 is any rejection of a new order.
+	if strings.Contains(message, "insufficient balance") {
+		kind = models.ErrInsufficientFunds
+	} else if strings.Contains(message, "Duplicate order sent") {
+		kind = models.ErrDuplicateOrder
+	} else if strings.Contains(message, "Filter failure") || strings.Contains(message, "MIN_NOTIONAL") {
+		kind = models.ErrFilter
+	}
+
+	if kind == models.ErrUnknown && code == 0 {
+		return err
+	}
+	return &models.ExchangeError{
+		Exchange: "binance",
+		Kind:     kind,
+		Code:     code,
+		Message:  message,
+	}
+}
@@ -0,0 +1,65 @@
+package infrastructure
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/OopsMouse/arbitgo/models"
+)
+
+func TestClassifyBinanceError(t *testing.T) {
+	cases := map[string]models.ErrorKind{
+		"<APIError> code=-2010, msg=Account has insufficient balance for requested action.":   models.ErrInsufficientFunds,
+		"<APIError> code=-1013, msg=Filter failure: LOT_SIZE":                                 models.ErrFilter,
+		"<APIError> code=-2013, msg=Order does not exist.":                                    models.ErrUnknownOrder,
+		"<APIError> code=-2015, msg=Invalid API-key, IP, or permissions for action.":          models.ErrAuth,
+		"<APIError> code=-1001, msg=Internal error; unable to process your request.":          models.ErrNetwork,
+		"<APIError> code=-1021, msg=Timestamp for this request is outside of the recvWindow.": models.ErrNetwork,
+		"<APIError> code=-1000, msg=An unknown error occured while processing the request.":   models.ErrUnknown,
+		"<APIError> code=-2010, msg=Market is closed.":                                        models.ErrRejected,
+		"something went wrong": models.ErrUnknown,
+	}
+	for message, kind := range cases {
+		if models.KindOf(classifyBinanceError(errors.New(message))) != kind {
+			t.Fatal("test failed")
+		}
+	}
+
+	// A code of no known kind rejects the request, sending it again would not
+	// help.
+	unmapped := classifyBinanceError(errors.New("<APIError> code=-1104, msg=Not all sent parameters were read."))
+	if models.KindOf(unmapped) != models.ErrRejected || models.IsRetryable(unmapped) {
+		t.Fatal("test failed")
+	}
+	for _, code := range []string{"-1000", "-1001", "-1006", "-1007", "-1021"} {
+		if !models.IsRetryable(classifyBinanceError(errors.New("<APIError> code=" + code + ", msg=transient"))) {
+			t.Fatal("test failed")
+		}
+	}
+
+	if models.KindOf(classifyBinanceError(io.EOF)) != models.ErrNetwork {
+		t.Fatal("test failed")
+	}
+
+	limited := &models.RateLimitError{Exchange: "binance", Until: time.Now()}
+	if classifyBinanceError(limited) != limited {
+		t.Fatal("test failed")
+	}
+
+	if classifyBinanceError(nil) != nil {
+		t.Fatal("test failed")
+	}
+}
+
+func TestStubRejectsFilterFailure(t *testing.T) {
+	stub, _ := newTestStub(newStubDepth(0.0001, 100, 0.00011, 100), FillSimulation{})
+	symbol := stubSymbol
+	symbol.MinNotional = 0.001
+	order := models.NewOrder("order", "depth", symbol, models.TypeLimit, models.SideBuy, 0.00011, 1)
+	err := stub.SendOrder(order)
+	if models.KindOf(err) != models.ErrFilter || models.IsRetryable(err) {
+		t.Fatal("test failed")
+	}
+}
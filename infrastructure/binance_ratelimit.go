@@ -0,0 +1,189 @@
+package infrastructure
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	binance "github.com/OopsMouse/go-binance"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	binanceWeightPerMinute = 1200
+	binanceOrdersPerSecond = 10
+	binanceOrdersPerDay    = 100000
+)
+
+// weightSyncInterval is how often the weight the exchange counts is read
+// back by a ping.
+const weightSyncInterval = 10 * time.Second
+
+// Request weights of the REST endpoints. The websockets are not limited.
+const (
+	weightDefault = 1
+	weightAccount = 5
+)
+
+func weightOfOrderBook(limit int) int {
+	if limit <= 100 {
+		return 1
+	} else if limit <= 500 {
+		return 5
+	}
+	return 10
+}
+
+// limitedBinance charges every REST call of the API to the rate limiter and
+// turns the errors of Binance into RateLimitErrors and ExchangeErrors. The
+// API does not expose its responses, so Ping is sent by the wrapper itself
+// for the limiter to observe the weight the exchange counts.
+type limitedBinance struct {
+	binance.Binance
+	limiter *RateLimiter
+	url     string
+	client  *http.Client
+}
+
+func newLimitedBinance(api binance.Binance, limiter *RateLimiter, url string) limitedBinance {
+	return limitedBinance{
+		Binance: api,
+		limiter: limiter,
+		url:     url,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// check classifies the error after the limiter has seen it.
+func (lb limitedBinance) check(err error) error {
+	return classifyBinanceError(lb.limiter.Check(err))
+}
+
+func (lb limitedBinance) Ping() error {
+	if err := lb.limiter.Wait(weightDefault); err != nil {
+		return err
+	}
+	res, err := lb.client.Get(lb.url + "/api/v1/ping")
+	if err != nil {
+		return lb.check(err)
+	}
+	defer res.Body.Close()
+	if err := lb.limiter.Observe(res.StatusCode, res.Header); err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return lb.check(fmt.Errorf("ping : %s", res.Status))
+	}
+	return nil
+}
+
+// syncWeight pings periodically so that the limiter follows the weight used
+// by the other clients of the same IP.
+func (lb limitedBinance) syncWeight() {
+	for range time.Tick(weightSyncInterval) {
+		if err := lb.Ping(); err != nil {
+			log.Warn("Failed to ping binance : ", err)
+		}
+	}
+}
+
+func (lb limitedBinance) Time() (time.Time, error) {
+	if err := lb.limiter.Wait(weightDefault); err != nil {
+		return time.Time{}, err
+	}
+	t, err := lb.Binance.Time()
+	return t, lb.check(err)
+}
+
+func (lb limitedBinance) ExchangeInfo() (*binance.ExchangeInfo, error) {
+	if err := lb.limiter.Wait(weightDefault); err != nil {
+		return nil, err
+	}
+	info, err := lb.Binance.ExchangeInfo()
+	return info, lb.check(err)
+}
+
+func (lb limitedBinance) OrderBook(obr binance.OrderBookRequest) (*binance.OrderBook, error) {
+	if err := lb.limiter.Wait(weightOfOrderBook(obr.Limit)); err != nil {
+		return nil, err
+	}
+	book, err := lb.Binance.OrderBook(obr)
+	return book, lb.check(err)
+}
+
+func (lb limitedBinance) Ticker24(tr binance.TickerRequest) (*binance.Ticker24, error) {
+	if err := lb.limiter.Wait(weightDefault); err != nil {
+		return nil, err
+	}
+	ticker, err := lb.Binance.Ticker24(tr)
+	return ticker, lb.check(err)
+}
+
+func (lb limitedBinance) NewOrder(nor binance.NewOrderRequest) (*binance.ProcessedOrder, error) {
+	if err := lb.limiter.WaitOrder(weightDefault); err != nil {
+		return nil, err
+	}
+	order, err := lb.Binance.NewOrder(nor)
+	return order, lb.check(err)
+}
+
+func (lb limitedBinance) NewOrderTest(nor binance.NewOrderRequest) error {
+	if err := lb.limiter.Wait(weightDefault); err != nil {
+		return err
+	}
+	return lb.check(lb.Binance.NewOrderTest(nor))
+}
+
+func (lb limitedBinance) QueryOrder(qor binance.QueryOrderRequest) (*binance.ExecutedOrder, error) {
+	if err := lb.limiter.Wait(weightDefault); err != nil {
+		return nil, err
+	}
+	order, err := lb.Binance.QueryOrder(qor)
+	return order, lb.check(err)
+}
+
+func (lb limitedBinance) CancelOrder(cor binance.CancelOrderRequest) (*binance.CanceledOrder, error) {
+	if err := lb.limiter.Wait(weightDefault); err != nil {
+		return nil, err
+	}
+	order, err := lb.Binance.CancelOrder(cor)
+	return order, lb.check(err)
+}
+
+func (lb limitedBinance) OpenOrders(oor binance.OpenOrdersRequest) ([]*binance.ExecutedOrder, error) {
+	if err := lb.limiter.Wait(weightDefault); err != nil {
+		return nil, err
+	}
+	orders, err := lb.Binance.OpenOrders(oor)
+	return orders, lb.check(err)
+}
+
+func (lb limitedBinance) Account(ar binance.AccountRequest) (*binance.Account, error) {
+	if err := lb.limiter.Wait(weightAccount); err != nil {
+		return nil, err
+	}
+	account, err := lb.Binance.Account(ar)
+	return account, lb.check(err)
+}
+
+func (lb limitedBinance) StartUserDataStream() (*binance.Stream, error) {
+	if err := lb.limiter.Wait(weightDefault); err != nil {
+		return nil, err
+	}
+	stream, err := lb.Binance.StartUserDataStream()
+	return stream, lb.check(err)
+}
+
+func (lb limitedBinance) KeepAliveUserDataStream(s *binance.Stream) error {
+	if err := lb.limiter.Wait(weightDefault); err != nil {
+		return err
+	}
+	return lb.check(lb.Binance.KeepAliveUserDataStream(s))
+}
+
+func (lb limitedBinance) CloseUserDataStream(s *binance.Stream) error {
+	if err := lb.limiter.Wait(weightDefault); err != nil {
+		return err
+	}
+	return lb.check(lb.Binance.CloseUserDataStream(s))
+}
@@ -0,0 +1,142 @@
+package infrastructure
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	binance "github.com/OopsMouse/go-binance"
+	"github.com/gorilla/websocket"
+	"github.com/jpillora/backoff"
+	"github.com/orcaman/concurrent-map"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	maxStreamsPerConnection = 200
+	streamReadTimeout       = 1 * time.Minute
+	streamStaleAfter        = 1 * time.Minute
+	streamHealthInterval    = 30 * time.Second
+)
+
+type streamMessage struct {
+	Stream string          `json:"stream"`
+	Data   json.RawMessage `json:"data"`
+}
+
+// combinedStream multiplexes many streams over one websocket connection of
+// the combined-stream endpoint, reconnecting whenever it drops or goes
+// silent. The time of the last message of each stream is kept in health.
+// connected, when set, is called on every connection.
+type combinedStream struct {
+	url       string
+	streams   []string
+	health    cmap.ConcurrentMap
+	handle    func(stream string, data json.RawMessage)
+	connected func()
+}
+
+func combinedStreamURL(base string, streams []string) string {
+	return base + "/stream?streams=" + strings.Join(streams, "/")
+}
+
+// chunkStreams splits the streams into groups small enough for a connection.
+func chunkStreams(streams []string, size int) [][]string {
+	chunks := [][]string{}
+	for len(streams) > size {
+		chunks = append(chunks, streams[:size])
+		streams = streams[size:]
+	}
+	if len(streams) > 0 {
+		chunks = append(chunks, streams)
+	}
+	return chunks
+}
+
+func (cs *combinedStream) run() {
+	b := &backoff.Backoff{
+		Max: 1 * time.Minute,
+	}
+	for {
+		err := cs.read(b)
+		log.Warn("Combined stream closed : ", err)
+		time.Sleep(b.Duration())
+	}
+}
+
+func (cs *combinedStream) read(b *backoff.Backoff) error {
+	c, _, err := websocket.DefaultDialer.Dial(combinedStreamURL(cs.url, cs.streams), nil)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if cs.connected != nil {
+		cs.connected()
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go cs.watch(done)
+
+	c.SetPingHandler(func(data string) error {
+		c.SetReadDeadline(time.Now().Add(streamReadTimeout))
+		return c.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(10*time.Second))
+	})
+
+	for {
+		c.SetReadDeadline(time.Now().Add(streamReadTimeout))
+		_, bytes, err := c.ReadMessage()
+		if err != nil {
+			return err
+		}
+		b.Reset()
+
+		var message streamMessage
+		err = json.Unmarshal(bytes, &message)
+		if err != nil || message.Stream == "" {
+			continue
+		}
+		cs.health.Set(message.Stream, time.Now())
+		cs.handle(message.Stream, message.Data)
+	}
+}
+
+// watch warns about the streams of the connection which went silent.
+func (cs *combinedStream) watch(done chan struct{}) {
+	ticker := time.NewTicker(streamHealthInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			stale := []string{}
+			for _, stream := range cs.streams {
+				last, ok := cs.health.Get(stream)
+				if !ok || time.Since(last.(time.Time)) > streamStaleAfter {
+					stale = append(stale, stream)
+				}
+			}
+			if len(stale) > 0 {
+				log.Warnf("%d of %d streams are stale : %s", len(stale), len(cs.streams), strings.Join(stale, ", "))
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+func parseBookLevels(levels [][]interface{}) []*binance.Order {
+	orders := []*binance.Order{}
+	for _, level := range levels {
+		if len(level) < 2 {
+			continue
+		}
+		price, _ := level[0].(string)
+		quantity, _ := level[1].(string)
+		orders = append(orders, &binance.Order{
+			Price:    parseFloat(price),
+			Quantity: parseFloat(quantity),
+		})
+	}
+	return orders
+}
@@ -0,0 +1,69 @@
+package infrastructure
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/jpillora/backoff"
+	"github.com/orcaman/concurrent-map"
+)
+
+func TestChunkStreams(t *testing.T) {
+	chunks := chunkStreams([]string{"a", "b", "c", "d", "e"}, 2)
+	if len(chunks) != 3 || len(chunks[2]) != 1 || chunks[1][0] != "c" {
+		t.Fatal("test failed")
+	}
+	if combinedStreamURL("wss://x", chunks[0]) != "wss://x/stream?streams=a/b" {
+		t.Fatal("test failed")
+	}
+}
+
+func TestCombinedStream(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("streams") != "ethbtc@depth20/xrpbtc@depth20" {
+			http.Error(w, "unknown streams", http.StatusBadRequest)
+			return
+		}
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		c.WriteMessage(websocket.TextMessage, []byte(`{"stream":"ethbtc@depth20","data":{"lastUpdateId":1}}`))
+		c.ReadMessage()
+	}))
+	defer server.Close()
+
+	messages := make(chan string, 1)
+	cs := &combinedStream{
+		url:     "ws" + strings.TrimPrefix(server.URL, "http"),
+		streams: []string{"ethbtc@depth20", "xrpbtc@depth20"},
+		health:  cmap.New(),
+		handle: func(stream string, data json.RawMessage) {
+			messages <- stream + " " + string(data)
+		},
+	}
+	go cs.read(&backoff.Backoff{})
+
+	select {
+	case message := <-messages:
+		if message != `ethbtc@depth20 {"lastUpdateId":1}` {
+			t.Fatal("test failed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("test failed")
+	}
+
+	if _, ok := cs.health.Get("ethbtc@depth20"); !ok {
+		t.Fatal("test failed")
+	}
+	if _, ok := cs.health.Get("xrpbtc@depth20"); ok {
+		t.Fatal("test failed")
+	}
+}
@@ -0,0 +1,189 @@
+package infrastructure
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	models "github.com/OopsMouse/arbitgo/models"
+	"github.com/OopsMouse/arbitgo/util"
+	binance "github.com/OopsMouse/go-binance"
+	"github.com/gorilla/websocket"
+	"github.com/jpillora/backoff"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	binanceStreamURL      = "wss://stream.binance.com:9443"
+	userDataKeepAlive     = 30 * time.Minute
+	userDataReadTimeout   = 5 * time.Minute
+	executionReportEvent  = "executionReport"
+	executionTypeCanceled = "CANCELED"
+)
+
+// userDataEvent is the part every message of the user data stream has.
+// encoding/json matches keys regardless of case when no field matches
+// exactly, so every key of the payload which differs from another one only by
+// case is declared, even when it is not used.
+type userDataEvent struct {
+	Event     string `json:"e"`
+	EventTime int64  `json:"E"`
+}
+
+type executionReport struct {
+	userDataEvent
+	Symbol           string `json:"s"`
+	ClientOrderID    string `json:"c"`
+	Side             string `json:"S"`
+	OrderType        string `json:"o"`
+	TimeInForce      string `json:"f"`
+	Quantity         string `json:"q"`
+	Price            string `json:"p"`
+	StopPrice        string `json:"P"`
+	IcebergQty       string `json:"F"`
+	OrigClientID     string `json:"C"`
+	ExecutionType    string `json:"x"`
+	Status           string `json:"X"`
+	RejectReason     string `json:"r"`
+	OrderID          int64  `json:"i"`
+	Ignore           int64  `json:"I"`
+	LastExecutedQty  string `json:"l"`
+	ExecutedQty      string `json:"z"`
+	LastPrice        string `json:"L"`
+	Commission       string `json:"n"`
+	CommissionAsset  string `json:"N"`
+	TransactionTime  int64  `json:"T"`
+	TradeID          int64  `json:"t"`
+	OnBook           bool   `json:"w"`
+	WorkingTime      int64  `json:"W"`
+	Maker            bool   `json:"m"`
+	IgnoreFlag       bool   `json:"M"`
+	CreationTime     int64  `json:"O"`
+	QuoteExecutedQty string `json:"Z"`
+	LastQuoteQty     string `json:"Y"`
+	QuoteOrderQty    string `json:"Q"`
+}
+
+func parseFloat(s string) float64 {
+	f, _ := strconv.ParseFloat(s, 64)
+	return f
+}
+
+func (r *executionReport) toOrderEvent() *models.OrderEvent {
+	orderID := r.ClientOrderID
+	if r.ExecutionType == executionTypeCanceled && r.OrigClientID != "" {
+		orderID = r.OrigClientID
+	}
+	side := models.SideSell
+	if r.Side == string(binance.SideBuy) {
+		side = models.SideBuy
+	}
+	return &models.OrderEvent{
+		Status:          models.OrderStatus(r.Status),
+		Exchange:        "binance",
+		OrderID:         orderID,
+		ExchangeOrderID: r.OrderID,
+		Symbol:          r.Symbol,
+		Side:            side,
+		Price:           parseFloat(r.Price),
+		Quantity:        parseFloat(r.Quantity),
+		LastExecutedQty: parseFloat(r.LastExecutedQty),
+		LastPrice:       parseFloat(r.LastPrice),
+		ExecutedQty:     parseFloat(r.ExecutedQty),
+		Commission:      parseFloat(r.Commission),
+		CommissionAsset: r.CommissionAsset,
+		Reason:          r.RejectReason,
+		Time:            time.Unix(0, r.TransactionTime*int64(time.Millisecond)),
+	}
+}
+
+func (bi Binance) startUserDataStream() (*binance.Stream, error) {
+	var stream *binance.Stream
+	err := util.BackoffRetry(5, func() error {
+		s, err := bi.Api.StartUserDataStream()
+		stream = s
+		return err
+	})
+	return stream, err
+}
+
+// GetOrderEvents opens the user data stream and delivers the execution
+// reports of our orders. The listen key is kept alive and the websocket is
+// reconnected, with a fresh listen key, whenever it drops.
+func (bi Binance) GetOrderEvents() chan *models.OrderEvent {
+	och := make(chan *models.OrderEvent, 256)
+
+	go func() {
+		b := &backoff.Backoff{
+			Max: 1 * time.Minute,
+		}
+		for {
+			stream, err := bi.startUserDataStream()
+			if err != nil {
+				log.Error("Failed to start user data stream : ", err)
+				time.Sleep(b.Duration())
+				continue
+			}
+
+			err = bi.readUserDataStream(stream, och, b)
+			log.Warn("User data stream closed : ", err)
+			bi.Api.CloseUserDataStream(stream)
+			time.Sleep(b.Duration())
+		}
+	}()
+
+	return och
+}
+
+func (bi Binance) readUserDataStream(stream *binance.Stream, och chan *models.OrderEvent, b *backoff.Backoff) error {
+	c, _, err := websocket.DefaultDialer.Dial(binanceStreamURL+"/ws/"+stream.ListenKey, nil)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		ticker := time.NewTicker(userDataKeepAlive)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				err := bi.Api.KeepAliveUserDataStream(stream)
+				if err != nil {
+					log.Error("Failed to keep alive user data stream : ", err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	c.SetPingHandler(func(data string) error {
+		c.SetReadDeadline(time.Now().Add(userDataReadTimeout))
+		return c.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(10*time.Second))
+	})
+
+	for {
+		c.SetReadDeadline(time.Now().Add(userDataReadTimeout))
+		_, bytes, err := c.ReadMessage()
+		if err != nil {
+			return err
+		}
+		b.Reset()
+
+		var event userDataEvent
+		if err := json.Unmarshal(bytes, &event); err != nil || event.Event != executionReportEvent {
+			continue
+		}
+		var report executionReport
+		if err := json.Unmarshal(bytes, &report); err != nil {
+			log.Error("Failed to parse execution report : ", err)
+			continue
+		}
+
+		och <- report.toOrderEvent()
+	}
+}
@@ -0,0 +1,41 @@
+package infrastructure
+
+import (
+	"encoding/json"
+	"testing"
+
+	models "github.com/OopsMouse/arbitgo/models"
+)
+
+// An execution report as the user data stream sends it.
+const executionReportPayload = `{
+  "e": "executionReport", "E": 1499405658658, "s": "ETHBTC",
+  "c": "mUvoqJxFIILMdfAW5iGSOW", "S": "BUY", "o": "LIMIT", "f": "GTC",
+  "q": "1.00000000", "p": "0.10264410", "P": "0.00000000", "F": "0.00000000",
+  "g": -1, "C": "", "x": "TRADE", "X": "PARTIALLY_FILLED", "r": "NONE",
+  "i": 4293153, "l": "0.40000000", "z": "0.60000000", "L": "0.10264000",
+  "n": "0.00040000", "N": "ETH", "T": 1499405658657, "t": 17, "I": 8641984,
+  "w": false, "m": false, "M": true, "O": 1499405658600,
+  "Z": "0.06158400", "Y": "0.04105600", "Q": "0.00000000",
+  "W": 1499405658600, "V": "NONE"
+}`
+
+func TestExecutionReportToOrderEvent(t *testing.T) {
+	var report executionReport
+	if err := json.Unmarshal([]byte(executionReportPayload), &report); err != nil {
+		t.Fatal(err)
+	}
+	event := report.toOrderEvent()
+
+	if event.OrderID != "mUvoqJxFIILMdfAW5iGSOW" || event.ExchangeOrderID != 4293153 ||
+		event.Status != models.StatusPartiallyFilled || event.Side != models.SideBuy {
+		t.Fatal("test failed")
+	}
+	if event.Price != 0.1026441 || event.Quantity != 1 || event.LastExecutedQty != 0.4 ||
+		event.ExecutedQty != 0.6 || event.LastPrice != 0.10264 || event.Commission != 0.0004 {
+		t.Fatal("test failed")
+	}
+	if event.Time.UnixNano() != 1499405658657*1000000 {
+		t.Fatal("test failed")
+	}
+}
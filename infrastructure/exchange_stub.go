@@ -3,30 +3,77 @@ package infrastructure
 import (
 	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	models "github.com/OopsMouse/arbitgo/models"
 	"github.com/OopsMouse/arbitgo/util"
 	log "github.com/sirupsen/logrus"
 )
 
-type executingOrder struct {
-	uncommit float64
-	order    *models.Order
-}
-
 type ExchangeStub struct {
 	Exchange
 	Balances        map[string]*models.Balance
 	ExecutingOrders map[string]*executingOrder
+	doneOrders      map[string]*models.Order
+	Simulation      FillSimulation
 	lock            *sync.Mutex
+	orderLock       *sync.Mutex
+	events          chan *models.OrderEvent
+	subscribed      *int32
+	matching        *sync.Once
+	clock           VirtualClock
 }
 
+// VirtualClock is the time of an exchange which replays it, such as
+// ExchangeStub over ReplayExchange. The stub advances it to the arrival of an
+// order instead of sleeping.
+type VirtualClock interface {
+	Now() time.Time
+	AdvanceTo(t time.Time)
+}
+
+const stubMatchingInterval = 100 * time.Millisecond
+
 func NewExchangeStub(ex Exchange, initialBalances map[string]*models.Balance) ExchangeStub {
+	return NewExchangeStubWithSimulation(ex, initialBalances, FillSimulation{})
+}
+
+func NewExchangeStubWithSimulation(ex Exchange, initialBalances map[string]*models.Balance, sim FillSimulation) ExchangeStub {
+	clock, _ := ex.(VirtualClock)
 	return ExchangeStub{
 		Exchange:        ex,
 		Balances:        initialBalances,
 		ExecutingOrders: map[string]*executingOrder{},
+		doneOrders:      map[string]*models.Order{},
+		Simulation:      sim,
 		lock:            new(sync.Mutex),
+		orderLock:       new(sync.Mutex),
+		events:          make(chan *models.OrderEvent, 1024),
+		subscribed:      new(int32),
+		matching:        new(sync.Once),
+		clock:           clock,
+	}
+}
+
+// now is the time of the wrapped exchange when it replays one.
+func (ex ExchangeStub) now() time.Time {
+	if ex.clock != nil {
+		return ex.clock.Now()
+	}
+	return time.Now()
+}
+
+// waitArrival lets the order reach the book, in virtual time when replayed.
+func (ex ExchangeStub) waitArrival(executingOrder *executingOrder) {
+	if ex.clock != nil {
+		if ex.clock.Now().Before(executingOrder.arrival) {
+			ex.clock.AdvanceTo(executingOrder.arrival)
+		}
+		return
+	}
+	if wait := executingOrder.arrival.Sub(time.Now()); wait > 0 {
+		time.Sleep(wait)
 	}
 }
 
@@ -44,153 +91,422 @@ func (ex ExchangeStub) NewBalance(asset string) {
 func (ex ExchangeStub) AddBalance(asset string, qty float64) {
 	defer ex.lock.Unlock()
 	ex.lock.Lock()
-	ex.NewBalance(asset)
-	balance := ex.Balances[asset]
-	ex.Balances[asset] = &models.Balance{
-		Asset: asset,
-		Free:  balance.Free + qty,
-		Total: balance.Total + qty,
-	}
+	ex.addBalance(asset, qty)
 }
 
 func (ex ExchangeStub) SubBalance(asset string, qty float64) {
 	defer ex.lock.Unlock()
 	ex.lock.Lock()
+	ex.addBalance(asset, -qty)
+}
+
+// addBalance replaces the balance so that copies handed out stay as they
+// were. The caller holds lock.
+func (ex ExchangeStub) addBalance(asset string, qty float64) {
 	ex.NewBalance(asset)
 	balance := ex.Balances[asset]
 	ex.Balances[asset] = &models.Balance{
 		Asset: asset,
-		Free:  balance.Free - qty,
-		Total: balance.Total - qty,
+		Free:  balance.Free + qty,
+		Total: balance.Total + qty,
 	}
 }
 
 func (ex ExchangeStub) GetBalances() ([]*models.Balance, error) {
+	defer ex.lock.Unlock()
+	ex.lock.Lock()
 	bs := []*models.Balance{}
 	for _, v := range ex.Balances {
-		bs = append(bs, v)
+		b := *v
+		bs = append(bs, &b)
 	}
 	return bs, nil
 }
 
 func (ex ExchangeStub) GetBalance(asset string) (*models.Balance, error) {
-	for k, b := range ex.Balances {
-		if k == asset {
-			return b, nil
-		}
+	defer ex.lock.Unlock()
+	ex.lock.Lock()
+	b := ex.Balances[asset]
+	if b == nil {
+		return nil, fmt.Errorf("Not found balance for %s", asset)
 	}
-	return nil, fmt.Errorf("Not found balance for %s", asset)
+	c := *b
+	return &c, nil
 }
 
 func (ex ExchangeStub) GetSymbols() []models.Symbol {
 	return ex.Exchange.GetSymbols()
 }
 
+// checkFilters rejects the order like the exchange would when it breaks the
+// filters of its symbol.
+func (ex ExchangeStub) checkFilters(order *models.Order) error {
+	symbol := order.Symbol
+	if order.Quantity < symbol.MinQty || (symbol.MaxQty > 0 && order.Quantity > symbol.MaxQty) {
+		return models.NewExchangeError(ex.Name(), models.ErrFilter, fmt.Sprintf("LOT_SIZE: %f", order.Quantity))
+	}
+	if order.OrderType == models.TypeLimit && order.Quantity*order.Price < symbol.MinNotional {
+		return models.NewExchangeError(ex.Name(), models.ErrFilter, fmt.Sprintf("MIN_NOTIONAL: %f", order.Quantity*order.Price))
+	}
+	return nil
+}
+
 func (ex ExchangeStub) SendOrder(order *models.Order) error {
-	ex.ExecutingOrders[order.ID] = &executingOrder{
-		uncommit: order.Quantity,
-		order:    order,
+	if err := ex.checkFilters(order); err != nil {
+		return err
+	}
+
+	defer ex.orderLock.Unlock()
+	ex.orderLock.Lock()
+	if ex.orderOf(order.ID) != nil {
+		return models.NewExchangeError(ex.Name(), models.ErrDuplicateOrder, "Duplicate order "+order.ID)
+	}
+
+	// Like the exchange, a limit order locks what it may spend until it ends.
+	asset, locked := lockOf(order)
+	if err := ex.lockBalance(asset, locked); err != nil {
+		return err
 	}
+
+	now := ex.now()
+	err := order.Transit(models.StatusNew, now)
+	if err != nil {
+		ex.unlockBalance(asset, locked)
+		return err
+	}
+
+	executingOrder := newExecutingOrder(order, ex.Simulation, now)
+	executingOrder.locked = locked
+	ex.ExecutingOrders[order.ID] = executingOrder
+	ex.emit(executingOrder.order, 0, 0, 0, "")
 	return nil
 }
 
 func (ex ExchangeStub) ConfirmOrder(order *models.Order) (float64, error) {
+	ex.orderLock.Lock()
 	executingOrder := ex.ExecutingOrders[order.ID]
+	done := ex.doneOrders[order.ID]
+	ex.orderLock.Unlock()
+
+	if done != nil {
+		*order = *done
+		return done.ExecutedQty, nil
+	}
+	if executingOrder == nil {
+		return 0, models.NewExchangeError(ex.Name(), models.ErrUnknownOrder, "Not found order "+order.ID)
+	}
+
+	ex.waitArrival(executingOrder)
+
+	defer ex.orderLock.Unlock()
+	ex.orderLock.Lock()
+	executed, err := ex.fill(executingOrder)
+	if err != nil {
+		return 0, err
+	}
+	*order = *executingOrder.order
+	return executed, nil
+}
+
+// QueryOrder updates the order with its state on the stub without matching
+// it against the depth. Done orders are found as well.
+func (ex ExchangeStub) QueryOrder(order *models.Order) error {
+	defer ex.orderLock.Unlock()
+	ex.orderLock.Lock()
+	found := ex.orderOf(order.ID)
+	if found == nil {
+		return models.NewExchangeError(ex.Name(), models.ErrUnknownOrder, "Not found order "+order.ID)
+	}
+	*order = *found
+	return nil
+}
+
+// orderOf returns the order executing or done with the ID, or nil. The caller
+// holds orderLock.
+func (ex ExchangeStub) orderOf(id string) *models.Order {
+	if executingOrder := ex.ExecutingOrders[id]; executingOrder != nil {
+		return executingOrder.order
+	}
+	return ex.doneOrders[id]
+}
+
+// finish moves the order out of the executing ones once it is done and
+// unlocks what it did not spend. The caller holds orderLock.
+func (ex ExchangeStub) finish(executingOrder *executingOrder) {
+	order := executingOrder.order
+	asset, _ := lockOf(order)
+	ex.unlockBalance(asset, executingOrder.locked)
+	executingOrder.locked = 0
+	delete(ex.ExecutingOrders, order.ID)
+	ex.doneOrders[order.ID] = order
+}
+
+// lockOf returns the asset and the quantity a limit order locks, which is
+// what it spends when it fills at its price.
+func lockOf(order *models.Order) (string, float64) {
+	if order.OrderType != models.TypeLimit {
+		return "", 0
+	}
+	if order.Side == models.SideBuy {
+		return order.Symbol.QuoteAsset, order.Quantity * order.Price
+	}
+	return order.Symbol.BaseAsset, order.Quantity
+}
+
+func (ex ExchangeStub) lockBalance(asset string, qty float64) error {
+	if qty <= 0 {
+		return nil
+	}
+	defer ex.lock.Unlock()
+	ex.lock.Lock()
+	balance := ex.Balances[asset]
+	if balance == nil || balance.Free < qty*(1-1e-9) {
+		free := 0.0
+		if balance != nil {
+			free = balance.Free
+		}
+		return models.NewExchangeError(ex.Name(), models.ErrInsufficientFunds, fmt.Sprintf("Insufficent balance: %s, %f < %f", asset, free, qty))
+	}
+	ex.Balances[asset] = &models.Balance{
+		Asset: asset,
+		Free:  balance.Free - qty,
+		Total: balance.Total,
+	}
+	return nil
+}
+
+func (ex ExchangeStub) unlockBalance(asset string, qty float64) {
+	defer ex.lock.Unlock()
+	ex.lock.Lock()
+	ex.unlock(asset, qty)
+}
+
+// unlock returns the locked quantity to the free balance. The caller holds
+// lock.
+func (ex ExchangeStub) unlock(asset string, qty float64) {
+	if qty <= 0 {
+		return
+	}
+	ex.NewBalance(asset)
+	balance := ex.Balances[asset]
+	ex.Balances[asset] = &models.Balance{
+		Asset: asset,
+		Free:  balance.Free + qty,
+		Total: balance.Total,
+	}
+}
+
+// fill matches the order against the current depth. The caller holds orderLock.
+func (ex ExchangeStub) fill(executingOrder *executingOrder) (float64, error) {
+	order := executingOrder.order
+	if executingOrder.uncommit <= 0 {
+		return executingOrder.executed(), nil
+	}
 
 	depth, err := ex.Exchange.GetDepth(order.Symbol)
 	if err != nil {
 		return 0, err
 	}
 
-	if order.OrderType == models.TypeMarket {
-		err := ex.CommitOrder(order, depth, order.Quantity)
+	log.Debugf("Symbol : %s, Bid : %f, %f, Ask : %f, %f", order.Symbol, depth.BidPrice, depth.BidQty, depth.AskPrice, depth.AskQty)
+
+	matched := executingOrder.match(depth, ex.Simulation)
+	if order.TimeInForce == models.FOK && matched < executingOrder.uncommit {
+		matched = 0
+	}
+
+	commitQty := util.Floor(matched, order.Symbol.StepSize)
+	price := fillPrice(order, depth)
+	commission := 0.0
+	if commitQty > 0 {
+		// The fill spends what the order locked for it.
+		unlocked := 0.0
+		if executingOrder.locked > 0 {
+			_, locked := lockOf(order)
+			unlocked = locked * commitQty / order.Quantity
+			if unlocked > executingOrder.locked {
+				unlocked = executingOrder.locked
+			}
+		}
+		commission, err = ex.commit(order, depth, commitQty, unlocked)
+		if err != nil {
+			return 0, err
+		}
+		executingOrder.locked -= unlocked
+		executingOrder.uncommit -= commitQty
+
+		err = order.Fill(commitQty, price, commission, ex.now())
 		if err != nil {
 			return 0, err
 		}
-		return order.Quantity, nil
 	}
 
-	var commitQty = 0.0
+	// IOC and FOK orders never rest on the book, what is left expires.
+	expired := order.IsImmediate() && !order.IsDone()
+	if expired {
+		order.Transit(models.StatusExpired, ex.now())
+	}
+
+	if order.IsDone() {
+		ex.finish(executingOrder)
+	}
+	if commitQty > 0 || expired {
+		ex.emit(order, commitQty, price, commission, "")
+	}
+
+	return executingOrder.executed(), nil
+}
+
+func fillPrice(order *models.Order, depth *models.Depth) float64 {
+	if order.OrderType == models.TypeLimit {
+		return order.Price
+	}
 	if order.Side == models.SideBuy {
-		log.Debugf("Symbol : %s, Price : %f, Quantity : %f", order.Symbol, depth.AskPrice, depth.AskQty)
-		if order.Price >= depth.AskPrice {
-			if executingOrder.uncommit <= depth.AskQty {
-				commitQty = executingOrder.uncommit
-			} else {
-				commitQty = executingOrder.uncommit - depth.AskQty
+		return depth.AskPrice
+	}
+	return depth.BidPrice
+}
+
+// GetOrderEvents starts matching the executing orders in the background so
+// that fills are reported without ConfirmOrder being polled.
+func (ex ExchangeStub) GetOrderEvents() chan *models.OrderEvent {
+	atomic.StoreInt32(ex.subscribed, 1)
+	ex.matching.Do(func() {
+		go ex.runMatching()
+	})
+	return ex.events
+}
+
+func (ex ExchangeStub) runMatching() {
+	for {
+		time.Sleep(stubMatchingInterval)
+
+		ex.orderLock.Lock()
+		for _, executingOrder := range ex.ExecutingOrders {
+			if ex.clock != nil {
+				ex.waitArrival(executingOrder)
+			} else if time.Now().Before(executingOrder.arrival) {
+				continue
 			}
-		} else {
-			commitQty = 0
-		}
-	} else {
-		log.Debugf("Symbol : %s, Price : %f, Quantity : %f", order.Symbol, depth.BidPrice, depth.BidQty)
-		if order.Price <= depth.BidPrice {
-			if executingOrder.uncommit <= depth.BidQty {
-				commitQty = executingOrder.uncommit
-			} else {
-				commitQty = executingOrder.uncommit - depth.BidQty
+			_, err := ex.fill(executingOrder)
+			if err != nil {
+				log.Error(err)
+				status := models.StatusRejected
+				if executingOrder.order.ExecutedQty > 0 {
+					status = models.StatusCanceled
+				}
+				executingOrder.order.Transit(status, ex.now())
+				ex.finish(executingOrder)
+				ex.emit(executingOrder.order, 0, 0, 0, err.Error())
 			}
-		} else {
-			commitQty = 0
 		}
+		ex.orderLock.Unlock()
 	}
+}
 
-	executingOrder.uncommit -= commitQty
-
-	err = ex.CommitOrder(order, depth, commitQty)
-	if err != nil {
-		return 0, err
+func (ex ExchangeStub) emit(order *models.Order, lastQty float64, lastPrice float64, commission float64, reason string) {
+	if atomic.LoadInt32(ex.subscribed) == 0 {
+		return
 	}
-
-	if executingOrder.uncommit <= 0 {
-		delete(ex.ExecutingOrders, order.ID)
+	event := &models.OrderEvent{
+		Status:          order.Status,
+		Exchange:        ex.Name(),
+		OrderID:         order.ID,
+		Symbol:          order.Symbol.String(),
+		Side:            order.Side,
+		Price:           order.Price,
+		Quantity:        order.Quantity,
+		LastExecutedQty: lastQty,
+		LastPrice:       lastPrice,
+		ExecutedQty:     order.ExecutedQty,
+		Commission:      commission,
+		CommissionAsset: order.CommissionAsset,
+		Reason:          reason,
+		Time:            order.UpdatedAt,
+	}
+	select {
+	case ex.events <- event:
+	default:
+		log.Warn("Drop order event of ", order.ID)
 	}
+}
 
-	return executingOrder.order.Quantity - executingOrder.uncommit, nil
+// CommitOrder moves the balances of a fill and charges the fee of the
+// exchange on what the order receives, which it returns. The balance is
+// checked and moved in one step.
+func (ex ExchangeStub) CommitOrder(order *models.Order, depth *models.Depth, qty float64) (float64, error) {
+	return ex.commit(order, depth, qty, 0)
 }
 
-func (ex ExchangeStub) CommitOrder(order *models.Order, depth *models.Depth, qty float64) error {
+// commit is CommitOrder for a fill which first unlocks the given quantity of
+// what the order spends.
+func (ex ExchangeStub) commit(order *models.Order, depth *models.Depth, qty float64, unlocked float64) (float64, error) {
+	fee := ex.GetFee()
+
+	defer ex.lock.Unlock()
+	ex.lock.Lock()
 
 	if order.Side == models.SideBuy {
-		balance, err := ex.GetBalance(order.Symbol.QuoteAsset)
-		if err != nil {
-			return err
+		balance := ex.Balances[order.Symbol.QuoteAsset]
+		if balance == nil {
+			return 0, models.NewExchangeError(ex.Name(), models.ErrInsufficientFunds, "Not found balance for "+order.Symbol.QuoteAsset)
 		}
 		var price float64
 		if order.OrderType == models.TypeLimit {
-			if balance.Free < util.Floor(qty, order.Symbol.StepSize)*order.Price {
-				return fmt.Errorf("Insufficent balance: %s, %f < %f", balance.Asset, balance.Free, qty*order.Price)
+			if balance.Free+unlocked < util.Floor(qty, order.Symbol.StepSize)*order.Price {
+				return 0, models.NewExchangeError(ex.Name(), models.ErrInsufficientFunds, fmt.Sprintf("Insufficent balance: %s, %f < %f", balance.Asset, balance.Free, qty*order.Price))
 			}
 			price = order.Price
 		} else {
 			price = depth.AskPrice
 		}
 
-		ex.SubBalance(order.Symbol.QuoteAsset, qty*price)
-		ex.AddBalance(order.Symbol.BaseAsset, qty)
-	} else {
-		balance, err := ex.GetBalance(order.Symbol.BaseAsset)
-		if err != nil {
-			return err
-		}
-		var price float64
-		if order.OrderType == models.TypeLimit {
-			if balance.Free < util.Floor(qty, order.Symbol.StepSize) {
-				return fmt.Errorf("Insufficent balance: %s, %f < %f", balance.Asset, balance.Free, qty)
-			}
-			price = order.Price
-		} else {
-			price = depth.BidPrice
-		}
+		commission := qty * fee
+		ex.unlock(order.Symbol.QuoteAsset, unlocked)
+		ex.addBalance(order.Symbol.QuoteAsset, -qty*price)
+		ex.addBalance(order.Symbol.BaseAsset, qty-commission)
+		order.CommissionAsset = order.Symbol.BaseAsset
+		return commission, nil
+	}
 
-		ex.AddBalance(order.Symbol.QuoteAsset, qty*price)
-		ex.SubBalance(order.Symbol.BaseAsset, qty)
+	balance := ex.Balances[order.Symbol.BaseAsset]
+	if balance == nil {
+		return 0, models.NewExchangeError(ex.Name(), models.ErrInsufficientFunds, "Not found balance for "+order.Symbol.BaseAsset)
 	}
-	return nil
+	var price float64
+	if order.OrderType == models.TypeLimit {
+		if balance.Free+unlocked < util.Floor(qty, order.Symbol.StepSize) {
+			return 0, models.NewExchangeError(ex.Name(), models.ErrInsufficientFunds, fmt.Sprintf("Insufficent balance: %s, %f < %f", balance.Asset, balance.Free, qty))
+		}
+		price = order.Price
+	} else {
+		price = depth.BidPrice
+	}
+
+	commission := qty * price * fee
+	ex.unlock(order.Symbol.BaseAsset, unlocked)
+	ex.addBalance(order.Symbol.QuoteAsset, qty*price-commission)
+	ex.addBalance(order.Symbol.BaseAsset, -qty)
+	order.CommissionAsset = order.Symbol.QuoteAsset
+	return commission, nil
 }
 
 func (ex ExchangeStub) CancelOrder(order *models.Order) error {
-	delete(ex.ExecutingOrders, order.ID)
+	defer ex.orderLock.Unlock()
+	ex.orderLock.Lock()
+	executingOrder := ex.ExecutingOrders[order.ID]
+	if executingOrder == nil {
+		// Like the exchange, done orders are unknown to cancel.
+		return models.NewExchangeError(ex.Name(), models.ErrUnknownOrder, "Not found order "+order.ID)
+	}
+
+	err := executingOrder.order.Transit(models.StatusCanceled, ex.now())
+	if err != nil {
+		return err
+	}
+	ex.finish(executingOrder)
+	*order = *executingOrder.order
+	ex.emit(executingOrder.order, 0, 0, 0, "")
 
 	return nil
 }
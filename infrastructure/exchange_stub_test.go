@@ -0,0 +1,317 @@
+package infrastructure
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/OopsMouse/arbitgo/models"
+)
+
+type depthExchange struct {
+	depth *models.Depth
+}
+
+func (ex *depthExchange) Name() string                            { return "depth" }
+func (ex *depthExchange) GetFee() float64                         { return 0.001 }
+func (ex *depthExchange) GetBalances() ([]*models.Balance, error) { return nil, nil }
+func (ex *depthExchange) GetQuotes() []string                     { return []string{"BTC"} }
+func (ex *depthExchange) GetSymbols() []models.Symbol             { return []models.Symbol{} }
+func (ex *depthExchange) GetDepthOnUpdate() chan *models.Depth    { return nil }
+func (ex *depthExchange) SendOrder(order *models.Order) error     { return nil }
+func (ex *depthExchange) CancelOrder(order *models.Order) error   { return nil }
+func (ex *depthExchange) QueryOrder(order *models.Order) error    { return nil }
+func (ex *depthExchange) GetOrderEvents() chan *models.OrderEvent { return nil }
+
+func (ex *depthExchange) ConfirmOrder(order *models.Order) (float64, error) {
+	return 0, nil
+}
+
+func (ex *depthExchange) GetDepth(symbol models.Symbol) (*models.Depth, error) {
+	if ex.depth == nil {
+		return nil, fmt.Errorf("Not found depth for %s", symbol)
+	}
+	return ex.depth, nil
+}
+
+var stubSymbol = models.Symbol{
+	Text:       "XRPBTC",
+	BaseAsset:  "XRP",
+	QuoteAsset: "BTC",
+	StepSize:   1,
+}
+
+func newStubDepth(bidPrice, bidQty, askPrice, askQty float64) *models.Depth {
+	return &models.Depth{
+		Symbol:   stubSymbol,
+		BidPrice: bidPrice,
+		BidQty:   bidQty,
+		AskPrice: askPrice,
+		AskQty:   askQty,
+	}
+}
+
+func newTestStub(depth *models.Depth, sim FillSimulation) (ExchangeStub, *depthExchange) {
+	inner := &depthExchange{depth: depth}
+	stub := NewExchangeStubWithSimulation(inner, map[string]*models.Balance{
+		"BTC": {Asset: "BTC", Free: 1.0, Total: 1.0},
+	}, sim)
+	return stub, inner
+}
+
+func newBuyOrder(price float64, qty float64) *models.Order {
+	return &models.Order{
+		ID:        "order",
+		Symbol:    stubSymbol,
+		OrderType: models.TypeLimit,
+		Side:      models.SideBuy,
+		Price:     price,
+		Quantity:  qty,
+	}
+}
+
+func TestStubPartialFill(t *testing.T) {
+	stub, inner := newTestStub(newStubDepth(0.000099, 500, 0.0001, 40), FillSimulation{})
+	order := newBuyOrder(0.0001, 100)
+	stub.SendOrder(order)
+
+	executed, _ := stub.ConfirmOrder(order)
+	if executed != 40 {
+		t.Fatalf("test failed %f", executed)
+	}
+
+	executed, _ = stub.ConfirmOrder(order)
+	if executed != 40 {
+		t.Fatalf("test failed %f", executed)
+	}
+
+	inner.depth = newStubDepth(0.000099, 500, 0.0001, 200)
+	executed, _ = stub.ConfirmOrder(order)
+	if executed != 100 {
+		t.Fatalf("test failed %f", executed)
+	}
+
+	// The fee is charged on the XRP received.
+	xrp, _ := stub.GetBalance("XRP")
+	if math.Abs(xrp.Free-99.9) > 1e-9 || math.Abs(order.Commission-0.1) > 1e-9 || order.CommissionAsset != "XRP" {
+		t.Fatal("test failed")
+	}
+}
+
+func TestStubQueuePosition(t *testing.T) {
+	stub, inner := newTestStub(newStubDepth(0.000099, 50, 0.0001, 40), FillSimulation{
+		QueuePosition: true,
+	})
+	order := newBuyOrder(0.000099, 100)
+	stub.SendOrder(order)
+
+	executed, _ := stub.ConfirmOrder(order)
+	if executed != 0 {
+		t.Fatalf("test failed %f", executed)
+	}
+
+	inner.depth = newStubDepth(0.000099, 50, 0.000099, 30)
+	executed, _ = stub.ConfirmOrder(order)
+	if executed != 0 {
+		t.Fatalf("test failed %f", executed)
+	}
+
+	inner.depth = newStubDepth(0.000099, 20, 0.000099, 100)
+	executed, _ = stub.ConfirmOrder(order)
+	if executed != 80 {
+		t.Fatalf("test failed %f", executed)
+	}
+}
+
+func TestStubLatency(t *testing.T) {
+	stub, inner := newTestStub(newStubDepth(0.000099, 500, 0.0001, 500), FillSimulation{
+		Latency: 50 * time.Millisecond,
+	})
+	order := newBuyOrder(0.0001, 100)
+	stub.SendOrder(order)
+
+	inner.depth = newStubDepth(0.0001, 500, 0.000101, 500)
+
+	start := time.Now()
+	executed, _ := stub.ConfirmOrder(order)
+	if executed != 0 || time.Now().Sub(start) < 50*time.Millisecond {
+		t.Fatalf("test failed %f", executed)
+	}
+}
+
+func TestStubOrderEvents(t *testing.T) {
+	stub, _ := newTestStub(newStubDepth(0.000099, 500, 0.0001, 500), FillSimulation{})
+	events := stub.GetOrderEvents()
+	order := newBuyOrder(0.0001, 100)
+	stub.SendOrder(order)
+
+	statuses := []models.OrderStatus{}
+	timeout := time.After(time.Second)
+	for len(statuses) < 2 {
+		select {
+		case event := <-events:
+			if event.OrderID != order.ID {
+				t.Fatal("test failed")
+			}
+			statuses = append(statuses, event.Status)
+			if event.Status == models.StatusFilled && event.ExecutedQty != 100 {
+				t.Fatalf("test failed %f", event.ExecutedQty)
+			}
+		case <-timeout:
+			t.Fatal("test failed")
+		}
+	}
+
+	if statuses[0] != models.StatusNew || statuses[1] != models.StatusFilled {
+		t.Fatal("test failed")
+	}
+}
+
+func TestStubImmediateOrCancel(t *testing.T) {
+	stub, _ := newTestStub(newStubDepth(0.000099, 500, 0.0001, 40), FillSimulation{})
+	order := newBuyOrder(0.0001, 100)
+	order.TimeInForce = models.IOC
+	stub.SendOrder(order)
+
+	executed, _ := stub.ConfirmOrder(order)
+	if executed != 40 || order.Status != models.StatusExpired {
+		t.Fatalf("test failed %f", executed)
+	}
+
+	if len(stub.ExecutingOrders) != 0 {
+		t.Fatal("test failed")
+	}
+}
+
+func TestStubFillOrKill(t *testing.T) {
+	stub, _ := newTestStub(newStubDepth(0.000099, 500, 0.0001, 40), FillSimulation{})
+	order := newBuyOrder(0.0001, 100)
+	order.TimeInForce = models.FOK
+	stub.SendOrder(order)
+
+	executed, _ := stub.ConfirmOrder(order)
+	if executed != 0 || order.Status != models.StatusExpired {
+		t.Fatalf("test failed %f", executed)
+	}
+
+	xrp, _ := stub.GetBalance("XRP")
+	if xrp != nil && xrp.Free != 0 {
+		t.Fatal("test failed")
+	}
+}
+
+func TestStubQueryOrder(t *testing.T) {
+	stub, _ := newTestStub(newStubDepth(0.0001, 100, 0.00011, 100), FillSimulation{})
+	order := models.NewOrder("order", "depth", stubSymbol, models.TypeLimit, models.SideBuy, 0.00009, 10)
+
+	if models.KindOf(stub.QueryOrder(order)) != models.ErrUnknownOrder {
+		t.Fatal("test failed")
+	}
+	if err := stub.SendOrder(order); err != nil {
+		t.Fatal(err)
+	}
+	if models.KindOf(stub.SendOrder(order)) != models.ErrDuplicateOrder {
+		t.Fatal("test failed")
+	}
+
+	queried := models.NewOrder("order", "depth", stubSymbol, models.TypeLimit, models.SideBuy, 0.00009, 10)
+	if err := stub.QueryOrder(queried); err != nil || queried.Status != models.StatusNew {
+		t.Fatal("test failed")
+	}
+
+	// Done orders are still found and their IDs cannot be reused.
+	if err := stub.CancelOrder(order); err != nil || order.Status != models.StatusCanceled {
+		t.Fatal("test failed")
+	}
+	if err := stub.QueryOrder(queried); err != nil || queried.Status != models.StatusCanceled {
+		t.Fatal("test failed")
+	}
+	if models.KindOf(stub.CancelOrder(order)) != models.ErrUnknownOrder {
+		t.Fatal("test failed")
+	}
+	reused := models.NewOrder("order", "depth", stubSymbol, models.TypeLimit, models.SideBuy, 0.00009, 10)
+	if models.KindOf(stub.SendOrder(reused)) != models.ErrDuplicateOrder {
+		t.Fatal("test failed")
+	}
+
+	filled := models.NewOrder("filled", "depth", stubSymbol, models.TypeLimit, models.SideBuy, 0.00011, 10)
+	stub.SendOrder(filled)
+	if executed, _ := stub.ConfirmOrder(filled); executed != 10 {
+		t.Fatal("test failed")
+	}
+	queried = models.NewOrder("filled", "depth", stubSymbol, models.TypeLimit, models.SideBuy, 0.00011, 10)
+	if err := stub.QueryOrder(queried); err != nil || queried.Status != models.StatusFilled || queried.ExecutedQty != 10 {
+		t.Fatal("test failed")
+	}
+}
+
+func TestStubCommitOrderConcurrently(t *testing.T) {
+	stub, _ := newTestStub(newStubDepth(0.000099, 500, 0.0001, 500), FillSimulation{})
+	depth := newStubDepth(0.000099, 500, 0.0001, 500)
+
+	// 1 BTC buys 10000 XRP once, the other commits must fail.
+	committed := int32(0)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := stub.CommitOrder(newBuyOrder(0.0001, 10000), depth, 10000); err == nil {
+				atomic.AddInt32(&committed, 1)
+			}
+			stub.GetBalances()
+		}()
+	}
+	wg.Wait()
+
+	btc, _ := stub.GetBalance("BTC")
+	if committed != 1 || btc.Free < -1e-9 {
+		t.Fatal("test failed")
+	}
+
+	// Balances handed out are copies.
+	btc.Free = 100
+	if b, _ := stub.GetBalance("BTC"); b.Free == 100 {
+		t.Fatal("test failed")
+	}
+}
+
+func TestStubLocksRestingOrders(t *testing.T) {
+	stub, inner := newTestStub(newStubDepth(0.000099, 500, 0.0001, 0), FillSimulation{})
+	order := models.NewOrder("order", "depth", stubSymbol, models.TypeLimit, models.SideBuy, 0.0001, 4000)
+	if err := stub.SendOrder(order); err != nil {
+		t.Fatal(err)
+	}
+
+	// The resting order locks its quote, the total is unchanged.
+	btc, _ := stub.GetBalance("BTC")
+	if math.Abs(btc.Free-0.6) > 1e-9 || math.Abs(btc.Total-1.0) > 1e-9 {
+		t.Fatalf("test failed %f %f", btc.Free, btc.Total)
+	}
+	other := models.NewOrder("other", "depth", stubSymbol, models.TypeLimit, models.SideBuy, 0.0001, 7000)
+	if models.KindOf(stub.SendOrder(other)) != models.ErrInsufficientFunds {
+		t.Fatal("test failed")
+	}
+
+	// A partial fill spends part of the lock.
+	inner.depth = newStubDepth(0.000099, 500, 0.0001, 1000)
+	if executed, _ := stub.ConfirmOrder(order); executed != 1000 {
+		t.Fatalf("test failed %f", executed)
+	}
+	btc, _ = stub.GetBalance("BTC")
+	if math.Abs(btc.Free-0.6) > 1e-9 || math.Abs(btc.Total-0.9) > 1e-9 {
+		t.Fatalf("test failed %f %f", btc.Free, btc.Total)
+	}
+
+	// Cancelling releases the rest.
+	if err := stub.CancelOrder(order); err != nil {
+		t.Fatal(err)
+	}
+	btc, _ = stub.GetBalance("BTC")
+	if math.Abs(btc.Free-0.9) > 1e-9 || math.Abs(btc.Total-0.9) > 1e-9 {
+		t.Fatalf("test failed %f %f", btc.Free, btc.Total)
+	}
+}
@@ -0,0 +1,147 @@
+package infrastructure
+
+import (
+	"math"
+	"time"
+
+	models "github.com/OopsMouse/arbitgo/models"
+)
+
+// FillSimulation configures how ExchangeStub fills orders. Latency delays an
+// order before it reaches the book, so it is matched against the depth at
+// arrival rather than at sending. With QueuePosition a resting limit order
+// waits behind the visible quantity at its price before it is filled.
+type FillSimulation struct {
+	Latency       time.Duration
+	QueuePosition bool
+}
+
+type executingOrder struct {
+	uncommit float64
+	order    *models.Order
+	arrival  time.Time
+	arrived  bool
+	queue    float64
+	depth    *models.Depth
+	consumed float64
+	locked   float64
+}
+
+// newExecutingOrder keeps its own copy of the order so that the lifecycle
+// seen by the stub is not mixed with the caller's.
+func newExecutingOrder(order *models.Order, sim FillSimulation, now time.Time) *executingOrder {
+	o := *order
+	return &executingOrder{
+		uncommit: order.Quantity,
+		order:    &o,
+		arrival:  now.Add(sim.Latency),
+	}
+}
+
+func (eo *executingOrder) executed() float64 {
+	return eo.order.ExecutedQty
+}
+
+// match returns the quantity the order fills against the given depth. The
+// liquidity of a depth is only used once across calls.
+func (eo *executingOrder) match(depth *models.Depth, sim FillSimulation) float64 {
+	available := crossingQuantity(eo.order, depth)
+	if eo.depth == depth {
+		available = math.Max(available-eo.consumed, 0)
+	} else {
+		eo.depth = depth
+		eo.consumed = 0
+	}
+
+	if !eo.arrived {
+		eo.arrived = true
+		commitQty := math.Min(eo.uncommit, available)
+		eo.consumed += commitQty
+		if sim.QueuePosition && eo.order.OrderType == models.TypeLimit {
+			eo.queue = aheadQuantity(eo.order, depth)
+		}
+		return commitQty
+	}
+
+	if eo.order.OrderType == models.TypeMarket {
+		commitQty := math.Min(eo.uncommit, available)
+		eo.consumed += commitQty
+		return commitQty
+	}
+
+	if sim.QueuePosition {
+		skip := math.Min(eo.queue, available)
+		eo.queue = math.Min(eo.queue-skip, aheadQuantity(eo.order, depth))
+		eo.consumed += skip
+		available -= skip
+	}
+
+	commitQty := math.Min(eo.uncommit, available)
+	eo.consumed += commitQty
+	return commitQty
+}
+
+// crossingQuantity is the visible quantity on the other side of the book the
+// order can take at its price.
+func crossingQuantity(order *models.Order, depth *models.Depth) float64 {
+	market := order.OrderType == models.TypeMarket
+	qty := 0.0
+	if order.Side == models.SideBuy {
+		if depth.OrderBook == nil {
+			if market || order.Price >= depth.AskPrice {
+				return depth.AskQty
+			}
+			return 0
+		}
+		for _, level := range depth.OrderBook.Asks {
+			if market || order.Price >= level.Price {
+				qty += level.Quantity
+			}
+		}
+	} else {
+		if depth.OrderBook == nil {
+			if market || order.Price <= depth.BidPrice {
+				return depth.BidQty
+			}
+			return 0
+		}
+		for _, level := range depth.OrderBook.Bids {
+			if market || order.Price <= level.Price {
+				qty += level.Quantity
+			}
+		}
+	}
+	return qty
+}
+
+// aheadQuantity is the visible quantity on the order's own side of the book
+// at the same or a better price, which is filled before the order.
+func aheadQuantity(order *models.Order, depth *models.Depth) float64 {
+	qty := 0.0
+	if order.Side == models.SideBuy {
+		if depth.OrderBook == nil {
+			if depth.BidPrice >= order.Price {
+				return depth.BidQty
+			}
+			return 0
+		}
+		for _, level := range depth.OrderBook.Bids {
+			if level.Price >= order.Price {
+				qty += level.Quantity
+			}
+		}
+	} else {
+		if depth.OrderBook == nil {
+			if depth.AskPrice <= order.Price {
+				return depth.AskQty
+			}
+			return 0
+		}
+		for _, level := range depth.OrderBook.Asks {
+			if level.Price <= order.Price {
+				qty += level.Quantity
+			}
+		}
+	}
+	return qty
+}
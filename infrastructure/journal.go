@@ -0,0 +1,418 @@
+package infrastructure
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"sync"
+	"time"
+
+	models "github.com/OopsMouse/arbitgo/models"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	sequencesBucket = []byte("sequences") // sequence ID -> bucket of its entries by number
+	ordersBucket    = []byte("orders")    // order ID -> last ORDER entry
+	openBucket      = []byte("open")      // order ID -> sequence ID, of the orders not done
+	balancesBucket  = []byte("balances")  // time -> BALANCE entry
+)
+
+// noSequence groups the entries of orders which belong to no sequence.
+const noSequence = "-"
+
+// The records queued while a transaction commits are written together in the
+// next one, up to journalBatchSize.
+const (
+	journalQueueSize = 1024
+	journalBatchSize = 256
+)
+
+var errJournalClosed = errors.New("Journal is closed")
+
+// journalWrite is a record to write, or a flush waiting for the records
+// queued before it when flushed is set.
+type journalWrite struct {
+	apply   func(tx *bolt.Tx) error
+	flushed chan struct{}
+}
+
+// BoltJournal is a journal stored in a BoltDB file. The entries of each
+// sequence are kept in order in a bucket of their own, and sequences sort by
+// time since their IDs are xids. Records are queued as they are and written
+// by a goroutine of their own, so that recording never waits for the disk.
+// Reads see every record queued before them.
+type BoltJournal struct {
+	db     *bolt.DB
+	writes chan journalWrite
+	done   chan struct{}
+	closed bool
+	lock   *sync.RWMutex
+}
+
+func NewBoltJournal(path string) (*BoltJournal, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{sequencesBucket, ordersBucket, openBucket, balancesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	j := &BoltJournal{
+		db:     db,
+		writes: make(chan journalWrite, journalQueueSize),
+		done:   make(chan struct{}),
+		lock:   new(sync.RWMutex),
+	}
+	go j.write()
+	return j, nil
+}
+
+// Close writes what is queued and closes the file.
+func (j *BoltJournal) Close() error {
+	j.lock.Lock()
+	if j.closed {
+		j.lock.Unlock()
+		return nil
+	}
+	j.closed = true
+	close(j.writes)
+	j.lock.Unlock()
+
+	<-j.done
+	return j.db.Close()
+}
+
+func (j *BoltJournal) enqueue(w journalWrite) error {
+	defer j.lock.RUnlock()
+	j.lock.RLock()
+	if j.closed {
+		return errJournalClosed
+	}
+	j.writes <- w
+	return nil
+}
+
+// flush waits until every record queued so far is written.
+func (j *BoltJournal) flush() {
+	flushed := make(chan struct{})
+	if j.enqueue(journalWrite{flushed: flushed}) == nil {
+		<-flushed
+	}
+}
+
+func (j *BoltJournal) write() {
+	defer close(j.done)
+	for w := range j.writes {
+		batch := []journalWrite{w}
+	collect:
+		for len(batch) < journalBatchSize {
+			select {
+			case w, ok := <-j.writes:
+				if !ok {
+					break collect
+				}
+				batch = append(batch, w)
+			default:
+				break collect
+			}
+		}
+		j.commit(batch)
+	}
+}
+
+// commit writes the batch in one transaction. When a record fails, the
+// records are written one by one so that only that one is lost.
+func (j *BoltJournal) commit(batch []journalWrite) {
+	err := j.db.Update(func(tx *bolt.Tx) error {
+		for _, w := range batch {
+			if w.apply == nil {
+				continue
+			}
+			if err := w.apply(tx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		for _, w := range batch {
+			if w.apply == nil {
+				continue
+			}
+			if err := j.db.Update(w.apply); err != nil {
+				log.Error("Failed to journal : ", err)
+			}
+		}
+	}
+	for _, w := range batch {
+		if w.flushed != nil {
+			close(w.flushed)
+		}
+	}
+}
+
+func (j *BoltJournal) RecordSequence(seq *models.Sequence) error {
+	entry := &models.JournalEntry{
+		Kind:       models.JournalSequence,
+		SequenceID: seq.ID,
+		Time:       time.Now(),
+		Legs:       models.LegsOf(seq),
+	}
+	return j.enqueue(journalWrite{apply: func(tx *bolt.Tx) error {
+		return appendEntry(tx, entry)
+	}})
+}
+
+// RecordOrder stores the order as it is now. When it changed since it was
+// last recorded, it is added to its sequence along with what it executed
+// since then and whether it was canceled.
+func (j *BoltJournal) RecordOrder(order *models.Order) error {
+	now := time.Now()
+	snapshot := *order
+	snapshot.Sequence = nil
+	key := []byte(order.ID)
+	orderSequenceID := ""
+	if order.Sequence != nil {
+		orderSequenceID = order.Sequence.ID
+	}
+
+	return j.enqueue(journalWrite{apply: func(tx *bolt.Tx) error {
+		orders := tx.Bucket(ordersBucket)
+		prev := &models.JournalEntry{}
+		found, err := getJSON(orders, key, prev)
+		if err != nil {
+			return err
+		}
+		last := prev.Order
+		if last == nil {
+			last = &models.Order{}
+		}
+
+		sequenceID := prev.SequenceID
+		if orderSequenceID != "" {
+			sequenceID = orderSequenceID
+		}
+		entry := &models.JournalEntry{
+			Kind:       models.JournalOrder,
+			SequenceID: sequenceID,
+			Time:       now,
+			Order:      &snapshot,
+		}
+		if err := putJSON(orders, key, entry); err != nil {
+			return err
+		}
+
+		open := tx.Bucket(openBucket)
+		if snapshot.IsDone() {
+			err = open.Delete(key)
+		} else {
+			err = open.Put(key, []byte(sequenceID))
+		}
+		if err != nil {
+			return err
+		}
+
+		if found && last.Status == snapshot.Status && last.ExecutedQty >= snapshot.ExecutedQty {
+			return nil
+		}
+		if err := appendEntry(tx, entry); err != nil {
+			return err
+		}
+
+		if qty := snapshot.ExecutedQty - last.ExecutedQty; qty > 0 {
+			price := (snapshot.AvgPrice*snapshot.ExecutedQty - last.AvgPrice*last.ExecutedQty) / qty
+			if price <= 0 {
+				price = snapshot.Price
+			}
+			fill := &models.Fill{
+				OrderID:         snapshot.ID,
+				Symbol:          snapshot.Symbol,
+				Side:            snapshot.Side,
+				Quantity:        qty,
+				Price:           price,
+				Commission:      snapshot.Commission - last.Commission,
+				CommissionAsset: snapshot.CommissionAsset,
+			}
+			err := appendEntry(tx, &models.JournalEntry{
+				Kind:       models.JournalFill,
+				SequenceID: sequenceID,
+				Time:       now,
+				Fill:       fill,
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		if isCancel(snapshot.Status) && !isCancel(last.Status) {
+			return appendEntry(tx, &models.JournalEntry{
+				Kind:       models.JournalCancel,
+				SequenceID: sequenceID,
+				Time:       now,
+				Order:      &snapshot,
+			})
+		}
+		return nil
+	}})
+}
+
+func isCancel(status models.OrderStatus) bool {
+	return status == models.StatusPendingCancel || status == models.StatusCanceled
+}
+
+// RecordBalances stores a snapshot of the balances, which is also added to the
+// sequence when one is given.
+func (j *BoltJournal) RecordBalances(sequenceID string, balances []*models.Balance) error {
+	now := time.Now()
+	copied := make([]*models.Balance, len(balances))
+	for i, b := range balances {
+		balance := *b
+		copied[i] = &balance
+	}
+	entry := &models.JournalEntry{
+		Kind:       models.JournalBalance,
+		SequenceID: sequenceID,
+		Time:       now,
+		Balances:   copied,
+	}
+	return j.enqueue(journalWrite{apply: func(tx *bolt.Tx) error {
+		if err := putJSON(tx.Bucket(balancesBucket), itob(uint64(now.UnixNano())), entry); err != nil {
+			return err
+		}
+		if sequenceID == "" {
+			return nil
+		}
+		return appendEntry(tx, entry)
+	}})
+}
+
+// OpenOrders returns the orders which were not done when last recorded.
+func (j *BoltJournal) OpenOrders() ([]*models.Order, error) {
+	j.flush()
+	orders := []*models.Order{}
+	err := j.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(openBucket).ForEach(func(k, v []byte) error {
+			entry := &models.JournalEntry{}
+			found, err := getJSON(tx.Bucket(ordersBucket), k, entry)
+			if err != nil {
+				return err
+			}
+			if found && entry.Order != nil {
+				orders = append(orders, entry.Order)
+			}
+			return nil
+		})
+	})
+	return orders, err
+}
+
+// Sequences returns the journals of the sequences which started between from
+// and to. Zero times do not bound.
+func (j *BoltJournal) Sequences(from time.Time, to time.Time) ([]*models.SequenceJournal, error) {
+	j.flush()
+	journals := []*models.SequenceJournal{}
+	err := j.db.View(func(tx *bolt.Tx) error {
+		sequences := tx.Bucket(sequencesBucket)
+		return sequences.ForEach(func(k, v []byte) error {
+			journal := &models.SequenceJournal{
+				ID:      string(k),
+				Entries: []*models.JournalEntry{},
+			}
+			err := sequences.Bucket(k).ForEach(func(_, v []byte) error {
+				entry := &models.JournalEntry{}
+				if err := json.Unmarshal(v, entry); err != nil {
+					return err
+				}
+				journal.Entries = append(journal.Entries, entry)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if len(journal.Entries) > 0 && within(journal.Entries[0].Time, from, to) {
+				journals = append(journals, journal)
+			}
+			return nil
+		})
+	})
+	return journals, err
+}
+
+// Balances returns the snapshots of the balances taken between from and to.
+// Zero times do not bound.
+func (j *BoltJournal) Balances(from time.Time, to time.Time) ([]*models.JournalEntry, error) {
+	j.flush()
+	entries := []*models.JournalEntry{}
+	err := j.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(balancesBucket).Cursor()
+		k, v := c.First()
+		if !from.IsZero() {
+			k, v = c.Seek(itob(uint64(from.UnixNano())))
+		}
+		for ; k != nil; k, v = c.Next() {
+			entry := &models.JournalEntry{}
+			if err := json.Unmarshal(v, entry); err != nil {
+				return err
+			}
+			if !within(entry.Time, from, to) {
+				break
+			}
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	return entries, err
+}
+
+func within(t time.Time, from time.Time, to time.Time) bool {
+	return (from.IsZero() || !t.Before(from)) && (to.IsZero() || t.Before(to))
+}
+
+func appendEntry(tx *bolt.Tx, entry *models.JournalEntry) error {
+	id := entry.SequenceID
+	if id == "" {
+		id = noSequence
+	}
+	b, err := tx.Bucket(sequencesBucket).CreateBucketIfNotExists([]byte(id))
+	if err != nil {
+		return err
+	}
+	n, err := b.NextSequence()
+	if err != nil {
+		return err
+	}
+	return putJSON(b, itob(n), entry)
+}
+
+func putJSON(b *bolt.Bucket, key []byte, v interface{}) error {
+	bytes, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return b.Put(key, bytes)
+}
+
+func getJSON(b *bolt.Bucket, key []byte, v interface{}) (bool, error) {
+	bytes := b.Get(key)
+	if bytes == nil {
+		return false, nil
+	}
+	return true, json.Unmarshal(bytes, v)
+}
+
+func itob(n uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, n)
+	return b
+}
@@ -0,0 +1,148 @@
+package infrastructure
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/OopsMouse/arbitgo/models"
+)
+
+func newTestJournal(t *testing.T) (*BoltJournal, string) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "journal.db")
+	journal, err := NewBoltJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return journal, path
+}
+
+func TestJournalSequence(t *testing.T) {
+	journal, path := newTestJournal(t)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	seq := &models.Sequence{
+		ID:       "seq",
+		Exchange: "test",
+		Symbol:   stubSymbol,
+		Side:     models.SideBuy,
+		From:     "BTC",
+		To:       "XRP",
+		Price:    0.0001,
+	}
+	if err := journal.RecordSequence(seq); err != nil {
+		t.Fatal(err)
+	}
+
+	order := models.NewOrder("order", "test", stubSymbol, models.TypeLimit, models.SideBuy, 0.0001, 100)
+	order.Sequence = seq
+	order.Transit(models.StatusNew, time.Now())
+	if err := journal.RecordOrder(order); err != nil {
+		t.Fatal(err)
+	}
+	order.Fill(40, 0.0001, 0, time.Now())
+	if err := journal.RecordOrder(order); err != nil {
+		t.Fatal(err)
+	}
+	if err := journal.RecordOrder(order); err != nil {
+		t.Fatal(err)
+	}
+	if err := journal.RecordBalances(seq.ID, []*models.Balance{{Asset: "BTC", Free: 1, Total: 1}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reopened as after a restart.
+	journal.Close()
+	journal, err := NewBoltJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+
+	orders, err := journal.OpenOrders()
+	if err != nil || len(orders) != 1 || orders[0].ExecutedQty != 40 {
+		t.Fatal("test failed")
+	}
+
+	restored := orders[0]
+	restored.Transit(models.StatusCanceled, time.Now())
+	if err := journal.RecordOrder(restored); err != nil {
+		t.Fatal(err)
+	}
+	orders, err = journal.OpenOrders()
+	if err != nil || len(orders) != 0 {
+		t.Fatal("test failed")
+	}
+
+	journals, err := journal.Sequences(time.Time{}, time.Time{})
+	if err != nil || len(journals) != 1 || journals[0].ID != seq.ID {
+		t.Fatal("test failed")
+	}
+	kinds := []models.JournalKind{}
+	for _, entry := range journals[0].Entries {
+		kinds = append(kinds, entry.Kind)
+	}
+	expected := []models.JournalKind{
+		models.JournalSequence,
+		models.JournalOrder,
+		models.JournalOrder,
+		models.JournalFill,
+		models.JournalBalance,
+		models.JournalOrder,
+		models.JournalCancel,
+	}
+	if len(kinds) != len(expected) {
+		t.Fatal("test failed", kinds)
+	}
+	for i := range kinds {
+		if kinds[i] != expected[i] {
+			t.Fatal("test failed", kinds)
+		}
+	}
+
+	pnl := journals[0].PnL()
+	if pnl["XRP"] != 40 || pnl["BTC"] > -0.004+1e-9 || pnl["BTC"] < -0.004-1e-9 {
+		t.Fatal("test failed", pnl)
+	}
+
+	balances, err := journal.Balances(time.Now().Add(-time.Minute), time.Time{})
+	if err != nil || len(balances) != 1 {
+		t.Fatal("test failed")
+	}
+	journals, err = journal.Sequences(time.Now().Add(time.Minute), time.Time{})
+	if err != nil || len(journals) != 0 {
+		t.Fatal("test failed")
+	}
+}
+
+func TestJournalQueuedWrites(t *testing.T) {
+	journal, path := newTestJournal(t)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	for i := 0; i < 500; i++ {
+		order := models.NewOrder(fmt.Sprint("order", i), "test", stubSymbol, models.TypeLimit, models.SideBuy, 0.0001, 100)
+		if err := journal.RecordOrder(order); err != nil {
+			t.Fatal(err)
+		}
+	}
+	journal.Close()
+	if journal.RecordOrder(models.NewOrder("late", "test", stubSymbol, models.TypeLimit, models.SideBuy, 0.0001, 100)) != errJournalClosed {
+		t.Fatal("test failed")
+	}
+
+	journal, err := NewBoltJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+	if orders, err := journal.OpenOrders(); err != nil || len(orders) != 500 {
+		t.Fatal("test failed")
+	}
+}
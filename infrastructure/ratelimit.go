@@ -0,0 +1,179 @@
+package infrastructure
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	models "github.com/OopsMouse/arbitgo/models"
+)
+
+type rateWindow struct {
+	length time.Duration
+	limit  int
+	start  time.Time
+	used   int
+}
+
+func (w *rateWindow) roll(now time.Time) {
+	if now.Sub(w.start) >= w.length {
+		w.start = now.Truncate(w.length)
+		w.used = 0
+	}
+}
+
+// wait returns how long until the weight fits in the window.
+func (w *rateWindow) wait(now time.Time, weight int) time.Duration {
+	w.roll(now)
+	if w.limit <= 0 || w.used+weight <= w.limit {
+		return 0
+	}
+	return w.start.Add(w.length).Sub(now)
+}
+
+// RateLimiter shares the request weight per minute and the order rate per
+// second and per day of an exchange between every caller. The windows are
+// aligned on the clock like the ones of Binance.
+type RateLimiter struct {
+	Exchange    string
+	lock        *sync.Mutex
+	weight      *rateWindow
+	orderSecond *rateWindow
+	orderDay    *rateWindow
+	until       time.Time
+	banned      bool
+	message     string
+}
+
+func NewRateLimiter(exchange string, weightPerMinute int, ordersPerSecond int, ordersPerDay int) *RateLimiter {
+	return &RateLimiter{
+		Exchange:    exchange,
+		lock:        new(sync.Mutex),
+		weight:      &rateWindow{length: time.Minute, limit: weightPerMinute},
+		orderSecond: &rateWindow{length: time.Second, limit: ordersPerSecond},
+		orderDay:    &rateWindow{length: 24 * time.Hour, limit: ordersPerDay},
+	}
+}
+
+// Wait blocks until the weight fits in the current minute. It fails at once
+// while the exchange refuses requests.
+func (l *RateLimiter) Wait(weight int) error {
+	return l.acquire(weight, false)
+}
+
+// WaitOrder is Wait for a request placing an order, which also counts against
+// the order rate. Running out of the daily orders fails instead of waiting.
+func (l *RateLimiter) WaitOrder(weight int) error {
+	return l.acquire(weight, true)
+}
+
+func (l *RateLimiter) acquire(weight int, order bool) error {
+	for {
+		l.lock.Lock()
+		now := time.Now()
+		if now.Before(l.until) {
+			err := l.errorOf(l.banned, l.until, l.message)
+			l.lock.Unlock()
+			return err
+		}
+
+		wait := l.weight.wait(now, weight)
+		if order {
+			if d := l.orderDay.wait(now, 1); d > 0 {
+				l.lock.Unlock()
+				return l.errorOf(false, now.Add(d), "daily order limit exceeded")
+			}
+			if d := l.orderSecond.wait(now, 1); d > wait {
+				wait = d
+			}
+		}
+
+		if wait <= 0 {
+			l.weight.used += weight
+			if order {
+				l.orderSecond.used++
+				l.orderDay.used++
+			}
+			l.lock.Unlock()
+			return nil
+		}
+		l.lock.Unlock()
+		time.Sleep(wait)
+	}
+}
+
+func (l *RateLimiter) errorOf(banned bool, until time.Time, message string) *models.RateLimitError {
+	return &models.RateLimitError{
+		Exchange: l.Exchange,
+		Banned:   banned,
+		Until:    until,
+		Message:  message,
+	}
+}
+
+// refuse fails every request until the given time. The caller holds lock.
+func (l *RateLimiter) refuse(banned bool, until time.Time, message string) error {
+	if until.After(l.until) {
+		l.until = until
+		l.banned = banned
+		l.message = message
+	}
+	return l.errorOf(banned, until, message)
+}
+
+// Observe takes the weight the exchange reports as used in the current minute
+// and, on a 429 or 418, refuses requests for as long as it asks. It is meant
+// for clients which expose their responses.
+func (l *RateLimiter) Observe(status int, header http.Header) error {
+	defer l.lock.Unlock()
+	l.lock.Lock()
+
+	now := time.Now()
+	if used, err := strconv.Atoi(header.Get("X-MBX-USED-WEIGHT")); err == nil {
+		l.weight.roll(now)
+		if used > l.weight.used {
+			l.weight.used = used
+		}
+	}
+
+	if status != http.StatusTooManyRequests && status != http.StatusTeapot {
+		return nil
+	}
+	retry := time.Minute
+	if s, err := strconv.Atoi(header.Get("Retry-After")); err == nil {
+		retry = time.Duration(s) * time.Second
+	}
+	return l.refuse(status == http.StatusTeapot, now.Add(retry), http.StatusText(status))
+}
+
+var bannedUntilPattern = regexp.MustCompile(`banned until (\d+)`)
+
+// Check turns an error telling that the limits were exceeded into a
+// RateLimitError, refusing requests meanwhile. Other errors are returned
+// as they are.
+func (l *RateLimiter) Check(err error) error {
+	if err == nil {
+		return nil
+	}
+	message := err.Error()
+
+	defer l.lock.Unlock()
+	l.lock.Lock()
+
+	now := time.Now()
+	if m := bannedUntilPattern.FindStringSubmatch(message); m != nil {
+		ms, _ := strconv.ParseInt(m[1], 10, 64)
+		return l.refuse(true, time.Unix(0, ms*int64(time.Millisecond)), message)
+	}
+	if strings.Contains(message, "-1003") || strings.Contains(message, "Too many requests") {
+		return l.refuse(false, now.Truncate(time.Minute).Add(time.Minute), message)
+	}
+	// Only the orders are limited, the other requests go on.
+	if strings.Contains(message, "-1015") || strings.Contains(message, "Too many new orders") {
+		return l.errorOf(false, now.Truncate(time.Second).Add(time.Second), message)
+	}
+	return err
+}
@@ -0,0 +1,101 @@
+package infrastructure
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	models "github.com/OopsMouse/arbitgo/models"
+)
+
+func TestRateLimiterWaitsForWindow(t *testing.T) {
+	limiter := NewRateLimiter("test", 0, 2, 0)
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := limiter.WaitOrder(1); err != nil {
+			t.Fatal("test failed")
+		}
+	}
+	if limiter.orderSecond.used != 1 || limiter.orderSecond.start.Before(start.Truncate(time.Second)) {
+		t.Fatal("test failed")
+	}
+}
+
+func TestRateLimiterDailyOrders(t *testing.T) {
+	limiter := NewRateLimiter("test", 0, 0, 1)
+
+	if err := limiter.WaitOrder(1); err != nil {
+		t.Fatal("test failed")
+	}
+	err := limiter.WaitOrder(1)
+	if e, ok := models.AsRateLimitError(err); !ok || e.Banned {
+		t.Fatal("test failed")
+	}
+	if err := limiter.Wait(1); err != nil {
+		t.Fatal("test failed")
+	}
+}
+
+func TestRateLimiterCheckBan(t *testing.T) {
+	limiter := NewRateLimiter("test", 1200, 10, 100000)
+
+	until := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+	ms := until.UnixNano() / int64(time.Millisecond)
+	err := limiter.Check(errors.New("-1003: Way too many requests; IP banned until " + strconv.FormatInt(ms, 10) + "."))
+	e, ok := models.AsRateLimitError(err)
+	if !ok || !e.Banned || !e.Until.Equal(until) {
+		t.Fatal("test failed")
+	}
+
+	if _, ok := models.AsRateLimitError(limiter.Wait(1)); !ok {
+		t.Fatal("test failed")
+	}
+
+	other := errors.New("-2010: Account has insufficient balance")
+	if limiter.Check(other) != other {
+		t.Fatal("test failed")
+	}
+}
+
+func TestRateLimiterObserve(t *testing.T) {
+	limiter := NewRateLimiter("test", 1200, 10, 100000)
+
+	header := http.Header{}
+	header.Set("X-MBX-USED-WEIGHT", "1100")
+	if err := limiter.Observe(http.StatusOK, header); err != nil {
+		t.Fatal("test failed")
+	}
+	if limiter.weight.used != 1100 {
+		t.Fatal("test failed")
+	}
+
+	header.Set("Retry-After", "30")
+	err := limiter.Observe(http.StatusTeapot, header)
+	if e, ok := models.AsRateLimitError(err); !ok || !e.Banned {
+		t.Fatal("test failed")
+	}
+	if _, ok := models.AsRateLimitError(limiter.Wait(1)); !ok {
+		t.Fatal("test failed")
+	}
+}
+
+func TestLimitedBinancePingObserves(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-MBX-USED-WEIGHT", "900")
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	limiter := NewRateLimiter("test", 1200, 10, 100000)
+	lb := newLimitedBinance(nil, limiter, server.URL)
+	if err := lb.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	if limiter.weight.used != 900 {
+		t.Fatal("test failed")
+	}
+}
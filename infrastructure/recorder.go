@@ -0,0 +1,217 @@
+package infrastructure
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	models "github.com/OopsMouse/arbitgo/models"
+	log "github.com/sirupsen/logrus"
+)
+
+const recordFileFormat = "depth-20060102-150405.000.jsonl.gz"
+
+// recordFlushInterval bounds what a crash loses of the current file.
+const recordFlushInterval = time.Second
+
+var errRecorderClosed = errors.New("recorder is closed")
+
+// DepthRecord is a line of a record file. The first line of a file is a
+// header listing the symbols recorded, without a depth.
+type DepthRecord struct {
+	Time    time.Time       `json:"time"`
+	Depth   *models.Depth   `json:"depth,omitempty"`
+	Symbols []models.Symbol `json:"symbols,omitempty"`
+}
+
+// DepthRecorder writes depthes as gzip compressed JSON lines, starting a new
+// file when the current one is older than interval or larger than maxSize
+// bytes before compression.
+type DepthRecorder struct {
+	dir      string
+	interval time.Duration
+	maxSize  int64
+	symbols  []models.Symbol
+	file     *os.File
+	gz       *gzip.Writer
+	opened   time.Time
+	written  int64
+	closed   bool
+	stop     chan struct{}
+	running  chan struct{}
+	lock     *sync.Mutex
+}
+
+func NewDepthRecorder(dir string, interval time.Duration, maxSize int64, symbols []models.Symbol) (*DepthRecorder, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &DepthRecorder{
+		dir:      dir,
+		interval: interval,
+		maxSize:  maxSize,
+		symbols:  symbols,
+		stop:     make(chan struct{}),
+		lock:     new(sync.Mutex),
+	}, nil
+}
+
+// Record writes the depthes of the channel until it is closed or the recorder
+// is, flushing the current file every recordFlushInterval.
+func (r *DepthRecorder) Record(depch chan *models.Depth) error {
+	r.lock.Lock()
+	if r.closed {
+		r.lock.Unlock()
+		return errRecorderClosed
+	}
+	running := make(chan struct{})
+	r.running = running
+	r.lock.Unlock()
+
+	err := r.record(depch)
+	close(running)
+	if err != nil {
+		return err
+	}
+	return r.Close()
+}
+
+func (r *DepthRecorder) record(depch chan *models.Depth) error {
+	ticker := time.NewTicker(recordFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case depth, ok := <-depch:
+			if !ok {
+				return nil
+			}
+			err := r.Write(depth)
+			if err == errRecorderClosed {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		case <-ticker.C:
+			if err := r.Flush(); err != nil {
+				return err
+			}
+		case <-r.stop:
+			return nil
+		}
+	}
+}
+
+func (r *DepthRecorder) Write(depth *models.Depth) error {
+	defer r.lock.Unlock()
+	r.lock.Lock()
+
+	if r.closed {
+		return errRecorderClosed
+	}
+
+	now := time.Now()
+	if r.file == nil ||
+		(r.interval > 0 && now.Sub(r.opened) >= r.interval) ||
+		(r.maxSize > 0 && r.written >= r.maxSize) {
+		err := r.rotate(now)
+		if err != nil {
+			return err
+		}
+	}
+
+	return r.writeRecord(&DepthRecord{
+		Time:  now,
+		Depth: depth,
+	})
+}
+
+func (r *DepthRecorder) writeRecord(record *DepthRecord) error {
+	bytes, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	bytes = append(bytes, '\n')
+
+	n, err := r.gz.Write(bytes)
+	r.written += int64(n)
+	return err
+}
+
+// Flush writes what is buffered of the current file, so that it can be read
+// up to there even if the recorder never closes it.
+func (r *DepthRecorder) Flush() error {
+	defer r.lock.Unlock()
+	r.lock.Lock()
+	if r.gz == nil {
+		return nil
+	}
+	return r.gz.Flush()
+}
+
+// Close stops Record, waiting for it to return, and closes the current file.
+// Writes fail afterwards.
+func (r *DepthRecorder) Close() error {
+	r.lock.Lock()
+	if !r.closed {
+		r.closed = true
+		close(r.stop)
+	}
+	running := r.running
+	r.lock.Unlock()
+
+	if running != nil {
+		<-running
+	}
+
+	defer r.lock.Unlock()
+	r.lock.Lock()
+	return r.close()
+}
+
+func (r *DepthRecorder) rotate(now time.Time) error {
+	err := r.close()
+	if err != nil {
+		return err
+	}
+
+	name := filepath.Join(r.dir, now.UTC().Format(recordFileFormat))
+	file, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+
+	log.Info("Recording depthes to ", name)
+
+	r.file = file
+	r.gz = gzip.NewWriter(file)
+	r.opened = now
+	r.written = 0
+	if len(r.symbols) == 0 {
+		return nil
+	}
+	return r.writeRecord(&DepthRecord{
+		Time:    now,
+		Symbols: r.symbols,
+	})
+}
+
+func (r *DepthRecorder) close() error {
+	if r.file == nil {
+		return nil
+	}
+	err := r.gz.Close()
+	if err != nil {
+		return err
+	}
+	err = r.file.Close()
+	r.file = nil
+	r.gz = nil
+	return err
+}
@@ -0,0 +1,130 @@
+package infrastructure
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/OopsMouse/arbitgo/models"
+)
+
+func TestDepthRecorder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "arbitgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	recorder, err := NewDepthRecorder(dir, 0, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, text := range []string{"XRPBTC", "ETHBTC"} {
+		err := recorder.Write(&models.Depth{
+			Symbol:   models.Symbol{Text: text},
+			BidPrice: 1.0,
+			AskPrice: 1.1,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = recorder.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.jsonl.gz"))
+	if len(files) != 1 {
+		t.Fatal("test failed")
+	}
+
+	file, _ := os.Open(files[0])
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records := []*DepthRecord{}
+	scanner := bufio.NewScanner(gz)
+	for scanner.Scan() {
+		var record *DepthRecord
+		err := json.Unmarshal(scanner.Bytes(), &record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+
+	if len(records) != 2 || records[1].Depth.Symbol.Text != "ETHBTC" || records[0].Time.IsZero() {
+		t.Fatal("test failed")
+	}
+}
+
+func TestDepthRecorderCloseWhileRecording(t *testing.T) {
+	dir, err := ioutil.TempDir("", "arbitgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Rotate on every write so that Close lands amid rotations.
+	recorder, err := NewDepthRecorder(dir, 0, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	depch := make(chan *models.Depth)
+	errch := make(chan error, 1)
+	go func() {
+		errch <- recorder.Record(depch)
+	}()
+	go func() {
+		for {
+			select {
+			case depch <- &models.Depth{Symbol: models.Symbol{Text: "XRPBTC"}}:
+			case <-time.After(100 * time.Millisecond):
+				return
+			}
+		}
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errch; err != nil {
+		t.Fatal(err)
+	}
+
+	if recorder.Write(&models.Depth{}) != errRecorderClosed {
+		t.Fatal("test failed")
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.jsonl.gz"))
+	if len(files) == 0 {
+		t.Fatal("test failed")
+	}
+	for _, name := range files {
+		file, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ioutil.ReadAll(gz); err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+	}
+}
@@ -0,0 +1,390 @@
+package infrastructure
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	models "github.com/OopsMouse/arbitgo/models"
+	"github.com/OopsMouse/arbitgo/util"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// ReplayExchange plays back depthes written by DepthRecorder. Speed 1 replays
+// in real time, a larger speed accelerates and 0 replays as fast as the
+// receiver reads. Depthes keep their recorded time, and Now and AdvanceTo make
+// the replay a virtual clock for ExchangeStub and the depth caches. Orders are
+// not accepted; wrap it with ExchangeStub to trade.
+type ReplayExchange struct {
+	files   []string
+	speed   float64
+	from    time.Time
+	to      time.Time
+	symbols []models.Symbol
+	quotes  *util.Set
+	depthes map[string]*models.Depth
+	now     time.Time
+	records *recordReader
+	next    *DepthRecord
+	pending []*models.Depth
+	lock    *sync.Mutex
+}
+
+func NewReplayExchange(path string, speed float64, from time.Time, to time.Time) (*ReplayExchange, error) {
+	files, err := recordFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, errors.Errorf("Not found records in %s", path)
+	}
+
+	ex := &ReplayExchange{
+		files:   files,
+		speed:   speed,
+		from:    from,
+		to:      to,
+		symbols: []models.Symbol{},
+		quotes:  util.NewSet(),
+		depthes: map[string]*models.Depth{},
+		lock:    new(sync.Mutex),
+	}
+
+	known := map[string]bool{}
+	add := func(symbol models.Symbol) {
+		if !known[symbol.String()] {
+			known[symbol.String()] = true
+			ex.symbols = append(ex.symbols, symbol)
+			ex.quotes.Append(symbol.QuoteAsset)
+		}
+	}
+	for _, file := range files {
+		symbols, err := readRecordHeader(file)
+		if err != nil {
+			return nil, err
+		}
+		if symbols != nil {
+			for _, symbol := range symbols {
+				add(symbol)
+			}
+			continue
+		}
+		// Files recorded without a header are scanned.
+		err = readDepthRecords(file, func(record *DepthRecord) error {
+			add(record.Depth.Symbol)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return ex, nil
+}
+
+func recordFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+	files, err := filepath.Glob(filepath.Join(path, "*.jsonl*"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// recordReader reads the depth records of files one after another.
+type recordReader struct {
+	files  []string
+	file   *os.File
+	gz     *gzip.Reader
+	reader *bufio.Reader
+}
+
+func newRecordReader(files []string) *recordReader {
+	return &recordReader{files: files}
+}
+
+func (r *recordReader) open() error {
+	name := r.files[0]
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	var reader io.Reader = f
+	if strings.HasSuffix(name, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		r.gz = gz
+		reader = gz
+	}
+	r.file = f
+	r.reader = bufio.NewReader(reader)
+	return nil
+}
+
+func (r *recordReader) closeFile() {
+	if r.gz != nil {
+		r.gz.Close()
+		r.gz = nil
+	}
+	if r.file != nil {
+		r.file.Close()
+		r.file = nil
+	}
+	r.reader = nil
+	r.files = r.files[1:]
+}
+
+// Next returns the next depth record, or io.EOF after the last file. Headers
+// and broken records are skipped and a truncated file ends where it breaks.
+func (r *recordReader) Next() (*DepthRecord, error) {
+	for len(r.files) > 0 {
+		if r.reader == nil {
+			if err := r.open(); err != nil {
+				return nil, err
+			}
+		}
+
+		line, err := r.reader.ReadBytes('\n')
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			var record *DepthRecord
+			if jsonErr := json.Unmarshal(line, &record); jsonErr != nil || record == nil {
+				log.Warn("Skip broken record in ", r.files[0])
+			} else if record.Depth != nil {
+				return record, nil
+			} else if record.Symbols == nil {
+				log.Warn("Skip broken record in ", r.files[0])
+			}
+		}
+		if err == io.ErrUnexpectedEOF {
+			log.Warn("Truncated record file ", r.files[0])
+			r.closeFile()
+		} else if err == io.EOF {
+			r.closeFile()
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	return nil, io.EOF
+}
+
+func (r *recordReader) Close() {
+	for r.reader != nil {
+		r.closeFile()
+	}
+	r.files = nil
+}
+
+func readDepthRecords(file string, fn func(record *DepthRecord) error) error {
+	reader := newRecordReader([]string{file})
+	defer reader.Close()
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+}
+
+// readRecordHeader returns the symbols listed by the first record of the
+// file, or nil when it has no header.
+func readRecordHeader(file string) ([]models.Symbol, error) {
+	reader := newRecordReader([]string{file})
+	defer reader.Close()
+	if err := reader.open(); err != nil {
+		return nil, err
+	}
+	line, err := reader.reader.ReadBytes('\n')
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	var record *DepthRecord
+	if json.Unmarshal(line, &record) != nil || record == nil || record.Depth != nil {
+		return nil, nil
+	}
+	return record.Symbols, nil
+}
+
+func (ex *ReplayExchange) Name() string {
+	return "replay"
+}
+
+func (ex *ReplayExchange) GetFee() float64 {
+	return 0.001
+}
+
+func (ex *ReplayExchange) GetBalances() ([]*models.Balance, error) {
+	return []*models.Balance{}, nil
+}
+
+func (ex *ReplayExchange) GetQuotes() []string {
+	return ex.quotes.ToSlice()
+}
+
+func (ex *ReplayExchange) GetSymbols() []models.Symbol {
+	return ex.symbols
+}
+
+func (ex *ReplayExchange) GetDepth(symbol models.Symbol) (*models.Depth, error) {
+	defer ex.lock.Unlock()
+	ex.lock.Lock()
+	depth := ex.depthes[symbol.String()]
+	if depth == nil {
+		return nil, errors.Errorf("Not found depth for %s", symbol)
+	}
+	return depth, nil
+}
+
+// Now returns the recorded time the replay has reached.
+func (ex *ReplayExchange) Now() time.Time {
+	defer ex.lock.Unlock()
+	ex.lock.Lock()
+	return ex.now
+}
+
+// AdvanceTo moves the replay up to the given time without waiting for the
+// receiver, which gets the depthes passed over later. GetDepth serves them
+// right away, like an exchange whose feed is behind.
+func (ex *ReplayExchange) AdvanceTo(t time.Time) {
+	defer ex.lock.Unlock()
+	ex.lock.Lock()
+	for {
+		record := ex.peek()
+		if record == nil || record.Time.After(t) {
+			break
+		}
+		ex.next = nil
+		ex.pending = append(ex.pending, ex.apply(record))
+	}
+	if t.After(ex.now) {
+		ex.now = t
+	}
+}
+
+// peek returns the next record within the range without consuming it, or nil
+// at the end. The caller holds lock.
+func (ex *ReplayExchange) peek() *DepthRecord {
+	for ex.next == nil && ex.records != nil {
+		record, err := ex.records.Next()
+		if err != nil {
+			if err != io.EOF {
+				log.Error(err)
+			}
+			ex.records.Close()
+			ex.records = nil
+			return nil
+		}
+		if !ex.from.IsZero() && record.Time.Before(ex.from) {
+			continue
+		}
+		if !ex.to.IsZero() && record.Time.After(ex.to) {
+			continue
+		}
+		ex.next = record
+	}
+	return ex.next
+}
+
+// apply makes the record the current depth of its symbol. The caller holds
+// lock.
+func (ex *ReplayExchange) apply(record *DepthRecord) *models.Depth {
+	depth := record.Depth
+	depth.Time = record.Time
+	if depth.OrderBook != nil {
+		depth.OrderBook.Time = record.Time
+	}
+	if record.Time.After(ex.now) {
+		ex.now = record.Time
+	}
+	ex.depthes[depth.Symbol.String()] = depth
+	return depth
+}
+
+// nextDepth returns the depth to deliver next, first those AdvanceTo passed
+// over, or nil at the end.
+func (ex *ReplayExchange) nextDepth() *models.Depth {
+	defer ex.lock.Unlock()
+	ex.lock.Lock()
+	if len(ex.pending) > 0 {
+		depth := ex.pending[0]
+		ex.pending = ex.pending[1:]
+		return depth
+	}
+	record := ex.peek()
+	if record == nil {
+		return nil
+	}
+	ex.next = nil
+	return ex.apply(record)
+}
+
+// GetDepthOnUpdate replays the records once and closes the channel at the end.
+func (ex *ReplayExchange) GetDepthOnUpdate() chan *models.Depth {
+	dch := make(chan *models.Depth)
+
+	ex.lock.Lock()
+	ex.records = newRecordReader(ex.files)
+	ex.lock.Unlock()
+
+	go func() {
+		defer close(dch)
+
+		var last time.Time
+		for {
+			depth := ex.nextDepth()
+			if depth == nil {
+				return
+			}
+			if ex.speed > 0 && !last.IsZero() && depth.Time.After(last) {
+				time.Sleep(time.Duration(float64(depth.Time.Sub(last)) / ex.speed))
+			}
+			last = depth.Time
+			dch <- depth
+		}
+	}()
+
+	return dch
+}
+
+func (ex *ReplayExchange) SendOrder(order *models.Order) error {
+	return errors.Errorf("Replay exchange does not accept orders")
+}
+
+func (ex *ReplayExchange) ConfirmOrder(order *models.Order) (float64, error) {
+	return 0, errors.Errorf("Replay exchange does not accept orders")
+}
+
+func (ex *ReplayExchange) QueryOrder(order *models.Order) error {
+	return errors.Errorf("Replay exchange does not accept orders")
+}
+
+func (ex *ReplayExchange) CancelOrder(order *models.Order) error {
+	return errors.Errorf("Replay exchange does not accept orders")
+}
+
+func (ex *ReplayExchange) GetOrderEvents() chan *models.OrderEvent {
+	return make(chan *models.OrderEvent)
+}
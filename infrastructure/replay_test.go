@@ -0,0 +1,156 @@
+package infrastructure
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/OopsMouse/arbitgo/models"
+)
+
+func writeRecords(t *testing.T, dir string, records []*DepthRecord) {
+	file, err := os.Create(filepath.Join(dir, "depth.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	for _, record := range records {
+		bytes, _ := json.Marshal(record)
+		file.Write(append(bytes, '\n'))
+	}
+}
+
+func TestReplayExchange(t *testing.T) {
+	dir, err := ioutil.TempDir("", "arbitgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	start := time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC)
+	xrp := models.Symbol{Text: "XRPBTC", BaseAsset: "XRP", QuoteAsset: "BTC"}
+	bnb := models.Symbol{Text: "BNBETH", BaseAsset: "BNB", QuoteAsset: "ETH"}
+	writeRecords(t, dir, []*DepthRecord{
+		{Time: start, Depth: &models.Depth{Symbol: xrp, BidPrice: 1.0}},
+		{Time: start.Add(1 * time.Second), Depth: &models.Depth{Symbol: bnb, BidPrice: 2.0}},
+		{Time: start.Add(2 * time.Second), Depth: &models.Depth{Symbol: xrp, BidPrice: 3.0}},
+		{Time: start.Add(3 * time.Second), Depth: &models.Depth{Symbol: xrp, BidPrice: 4.0}},
+	})
+
+	ex, err := NewReplayExchange(dir, 0, time.Time{}, start.Add(2*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ex.GetSymbols()) != 2 || len(ex.GetQuotes()) != 2 {
+		t.Fatal("test failed")
+	}
+
+	prices := []float64{}
+	for depth := range ex.GetDepthOnUpdate() {
+		prices = append(prices, depth.BidPrice)
+	}
+
+	if len(prices) != 3 || prices[0] != 1.0 || prices[2] != 3.0 {
+		t.Fatal("test failed")
+	}
+
+	depth, err := ex.GetDepth(xrp)
+	if err != nil || depth.BidPrice != 3.0 || !depth.Time.Equal(start.Add(2*time.Second)) {
+		t.Fatal("test failed")
+	}
+
+	if !ex.Now().Equal(start.Add(2 * time.Second)) {
+		t.Fatal("test failed")
+	}
+}
+
+func TestReplayExchangeRecorded(t *testing.T) {
+	dir, err := ioutil.TempDir("", "arbitgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	symbols := []models.Symbol{
+		{Text: "XRPBTC", BaseAsset: "XRP", QuoteAsset: "BTC"},
+		{Text: "ETHBTC", BaseAsset: "ETH", QuoteAsset: "BTC"},
+		{Text: "BNBUSDT", BaseAsset: "BNB", QuoteAsset: "USDT"},
+	}
+	recorder, _ := NewDepthRecorder(dir, 0, 0, symbols)
+	recorder.Write(&models.Depth{Symbol: symbols[0]})
+	recorder.Write(&models.Depth{Symbol: symbols[1]})
+	recorder.Close()
+
+	ex, err := NewReplayExchange(dir, 0, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Symbols come from the header, BNBUSDT was never recorded.
+	if len(ex.GetSymbols()) != 3 || len(ex.GetQuotes()) != 2 {
+		t.Fatal("test failed")
+	}
+
+	count := 0
+	for range ex.GetDepthOnUpdate() {
+		count++
+	}
+	if count != 2 {
+		t.Fatal("test failed")
+	}
+}
+
+func TestReplayStubLatency(t *testing.T) {
+	dir, err := ioutil.TempDir("", "arbitgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	start := time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC)
+	writeRecords(t, dir, []*DepthRecord{
+		{Time: start, Depth: newStubDepth(0.0001, 500, 0.000101, 500)},
+		{Time: start.Add(1 * time.Second), Depth: newStubDepth(0.000099, 500, 0.0001, 500)},
+		{Time: start.Add(3 * time.Second), Depth: newStubDepth(0.000098, 500, 0.000099, 500)},
+	})
+
+	replay, err := NewReplayExchange(dir, 0, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub := NewExchangeStubWithSimulation(replay, map[string]*models.Balance{
+		"BTC": {Asset: "BTC", Free: 1.0, Total: 1.0},
+	}, FillSimulation{Latency: 1500 * time.Millisecond})
+
+	depch := replay.GetDepthOnUpdate()
+	if first := <-depch; !first.Time.Equal(start) {
+		t.Fatal("test failed")
+	}
+
+	// The order arrives at the second depth in recorded time, without
+	// sleeping and before the receiver has read it.
+	order := newBuyOrder(0.0001, 100)
+	stub.SendOrder(order)
+	began := time.Now()
+	executed, err := stub.ConfirmOrder(order)
+	if err != nil || executed != 100 || time.Now().Sub(began) > time.Second {
+		t.Fatalf("test failed %f", executed)
+	}
+	now := replay.Now()
+	if now.Before(start.Add(1500*time.Millisecond)) || !now.Before(start.Add(3*time.Second)) || !order.UpdatedAt.Equal(now) {
+		t.Fatal("test failed")
+	}
+
+	// The depthes passed over are still delivered in order.
+	prices := []float64{}
+	for depth := range depch {
+		prices = append(prices, depth.AskPrice)
+	}
+	if len(prices) != 2 || prices[0] != 0.0001 || prices[1] != 0.000099 {
+		t.Fatal("test failed")
+	}
+}
@@ -0,0 +1,93 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RateLimitError is returned when a request is refused because the rate limit
+// of the exchange is exceeded, or because the IP is banned for having
+// exceeded it. Nothing should be sent to the exchange before Until.
+type RateLimitError struct {
+	Exchange string
+	Banned   bool
+	Until    time.Time
+	Message  string
+}
+
+func (e *RateLimitError) Error() string {
+	if e.Banned {
+		return fmt.Sprintf("%s: banned until %s: %s", e.Exchange, e.Until.Format(time.RFC3339), e.Message)
+	}
+	return fmt.Sprintf("%s: rate limited until %s: %s", e.Exchange, e.Until.Format(time.RFC3339), e.Message)
+}
+
+// AsRateLimitError returns the rate limit error the given error was caused by.
+func AsRateLimitError(err error) (*RateLimitError, bool) {
+	if err == nil {
+		return nil, false
+	}
+	e, ok := errors.Cause(err).(*RateLimitError)
+	return e, ok
+}
+
+type ErrorKind string
+
+const (
+	ErrUnknown           = ErrorKind("UNKNOWN")
+	ErrNetwork           = ErrorKind("NETWORK")
+	ErrRateLimited       = ErrorKind("RATE_LIMITED")
+	ErrInsufficientFunds = ErrorKind("INSUFFICIENT_FUNDS")
+	ErrFilter            = ErrorKind("FILTER")
+	ErrUnknownOrder      = ErrorKind("UNKNOWN_ORDER")
+	ErrDuplicateOrder    = ErrorKind("DUPLICATE_ORDER")
+	ErrAuth              = ErrorKind("AUTH")
+	ErrRejected          = ErrorKind("REJECTED")
+)
+
+// ExchangeError is an error of an exchange classified by its kind. Code is
+// the code of the exchange when it gave one.
+type ExchangeError struct {
+	Exchange string
+	Kind     ErrorKind
+	Code     int
+	Message  string
+}
+
+func (e *ExchangeError) Error() string {
+	if e.Code != 0 {
+		return fmt.Sprintf("%s: %s: %d %s", e.Exchange, e.Kind, e.Code, e.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", e.Exchange, e.Kind, e.Message)
+}
+
+func NewExchangeError(exchange string, kind ErrorKind, message string) *ExchangeError {
+	return &ExchangeError{
+		Exchange: exchange,
+		Kind:     kind,
+		Message:  message,
+	}
+}
+
+// KindOf returns the kind of the error. Errors which were not classified are
+// of ErrUnknown.
+func KindOf(err error) ErrorKind {
+	switch e := errors.Cause(err).(type) {
+	case nil:
+		return ""
+	case *RateLimitError:
+		return ErrRateLimited
+	case *ExchangeError:
+		return e.Kind
+	}
+	return ErrUnknown
+}
+
+// IsRetryable tells whether sending the same request again may succeed. The
+// errors telling that the request itself is refused are not.
+func IsRetryable(err error) bool {
+	kind := KindOf(err)
+	return kind == ErrNetwork || kind == ErrUnknown
+}
@@ -0,0 +1,104 @@
+package models
+
+import (
+	"time"
+)
+
+type JournalKind string
+
+const (
+	JournalSequence = JournalKind("SEQUENCE")
+	JournalOrder    = JournalKind("ORDER")
+	JournalFill     = JournalKind("FILL")
+	JournalCancel   = JournalKind("CANCEL")
+	JournalBalance  = JournalKind("BALANCE")
+)
+
+// JournalEntry is a record of the trade journal. Entries of a sequence share
+// its ID, and only the field of their kind is set.
+type JournalEntry struct {
+	Kind       JournalKind `json:"kind"`
+	SequenceID string      `json:"sequence_id,omitempty"`
+	Time       time.Time   `json:"time"`
+	Legs       []Leg       `json:"legs,omitempty"`
+	Order      *Order      `json:"order,omitempty"`
+	Fill       *Fill       `json:"fill,omitempty"`
+	Balances   []*Balance  `json:"balances,omitempty"`
+}
+
+// Leg is a sequence step as journaled, without the links of Sequence.
+type Leg struct {
+	Exchange string    `json:"exchange"`
+	Symbol   Symbol    `json:"symbol"`
+	Side     OrderSide `json:"side"`
+	From     string    `json:"from"`
+	To       string    `json:"to"`
+	Price    float64   `json:"price"`
+	Quantity float64   `json:"quantity"`
+	Target   float64   `json:"target"`
+	Score    float64   `json:"score"`
+}
+
+// Fill is an execution of an order.
+type Fill struct {
+	OrderID         string    `json:"order_id"`
+	Symbol          Symbol    `json:"symbol"`
+	Side            OrderSide `json:"side"`
+	Quantity        float64   `json:"quantity"`
+	Price           float64   `json:"price"`
+	Commission      float64   `json:"commission"`
+	CommissionAsset string    `json:"commission_asset,omitempty"`
+}
+
+func LegsOf(seq *Sequence) []Leg {
+	legs := []Leg{}
+	for s := seq; s != nil; s = s.Next {
+		legs = append(legs, Leg{
+			Exchange: s.Exchange,
+			Symbol:   s.Symbol,
+			Side:     s.Side,
+			From:     s.From,
+			To:       s.To,
+			Price:    s.Price,
+			Quantity: s.Quantity,
+			Target:   s.Target,
+			Score:    s.Score,
+		})
+	}
+	return legs
+}
+
+// SequenceJournal is what was journaled of a sequence, oldest first.
+type SequenceJournal struct {
+	ID      string          `json:"id"`
+	Entries []*JournalEntry `json:"entries"`
+}
+
+// PnL returns what the fills of the sequence gained or lost of each asset.
+// A completed cycle leaves only its starting asset, a broken one also what
+// was stranded.
+func (j *SequenceJournal) PnL() map[string]float64 {
+	pnl := map[string]float64{}
+	for _, entry := range j.Entries {
+		fill := entry.Fill
+		if entry.Kind != JournalFill || fill == nil {
+			continue
+		}
+		if fill.Side == SideBuy {
+			pnl[fill.Symbol.BaseAsset] += fill.Quantity
+			pnl[fill.Symbol.QuoteAsset] -= fill.Quantity * fill.Price
+		} else {
+			pnl[fill.Symbol.BaseAsset] -= fill.Quantity
+			pnl[fill.Symbol.QuoteAsset] += fill.Quantity * fill.Price
+		}
+		if fill.CommissionAsset != "" {
+			pnl[fill.CommissionAsset] -= fill.Commission
+		}
+	}
+	for asset, value := range pnl {
+		if value > -1e-12 && value < 1e-12 {
+			delete(pnl, asset)
+		}
+	}
+	return pnl
+}
@@ -8,12 +8,18 @@ type OrderSide string
 
 type OrderType string
 
+type TimeInForce string
+
 const (
 	SideBuy  = OrderSide("BUY")
 	SideSell = OrderSide("Sell")
 
 	TypeLimit  = OrderType("LIMIT")
 	TypeMarket = OrderType("MARKET")
+
+	GTC = TimeInForce("GTC") // rests on the book until filled or canceled
+	IOC = TimeInForce("IOC") // fills what it can at once and expires the rest
+	FOK = TimeInForce("FOK") // fills entirely at once or expires
 )
 
 type Symbol struct {
@@ -55,6 +61,8 @@ func (symbs Symbols) Swap(i, j int) {
 }
 
 type Sequence struct {
+	ID       string
+	Exchange string
 	Symbol   Symbol
 	Side     OrderSide
 	From     string
@@ -62,33 +70,67 @@ type Sequence struct {
 	Price    float64
 	Quantity float64
 	Target   float64
+	Score    float64
 	Src      *Depth
 	Next     *Sequence
 }
 
 type Order struct {
-	ID        string
-	Symbol    Symbol
-	OrderType OrderType
-	Price     float64
-	Side      OrderSide
-	Quantity  float64
-	Sequence  *Sequence
+	ID              string
+	Exchange        string
+	ExchangeOrderID int64
+	Symbol          Symbol
+	OrderType       OrderType
+	TimeInForce     TimeInForce
+	Price           float64
+	Side            OrderSide
+	Quantity        float64
+	Status          OrderStatus
+	ExecutedQty     float64
+	AvgPrice        float64
+	Commission      float64
+	CommissionAsset string
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+	Sequence        *Sequence
 }
 
 type Depth struct {
-	BaseAsset  string    `json:"base_asset"`
-	QuoteAsset string    `json:"quote_asset"`
-	Symbol     Symbol    `json:"symbol"`
-	BidPrice   float64   `json:"bid_price"`
-	AskPrice   float64   `json:"ask_price"`
-	BidQty     float64   `json:"bid_qty"`
-	AskQty     float64   `json:"ask_qty"`
-	Time       time.Time `json:"time"`
+	Exchange   string     `json:"exchange,omitempty"`
+	BaseAsset  string     `json:"base_asset"`
+	QuoteAsset string     `json:"quote_asset"`
+	Symbol     Symbol     `json:"symbol"`
+	BidPrice   float64    `json:"bid_price"`
+	AskPrice   float64    `json:"ask_price"`
+	BidQty     float64    `json:"bid_qty"`
+	AskQty     float64    `json:"ask_qty"`
+	Time       time.Time  `json:"time"`       // received
+	EventTime  time.Time  `json:"event_time"` // sent by the exchange
+	UpdateID   int64      `json:"update_id,omitempty"`
+	OrderBook  *OrderBook `json:"order_book,omitempty"`
 }
 
 type Balance struct {
-	Asset string
-	Free  float64
-	Total float64
+	Exchange string
+	Asset    string
+	Free     float64
+	Total    float64
+}
+
+type OrderEvent struct {
+	Status          OrderStatus
+	Exchange        string
+	OrderID         string
+	ExchangeOrderID int64
+	Symbol          string
+	Side            OrderSide
+	Price           float64
+	Quantity        float64
+	LastExecutedQty float64
+	LastPrice       float64
+	ExecutedQty     float64
+	Commission      float64
+	CommissionAsset string
+	Reason          string
+	Time            time.Time
 }
@@ -0,0 +1,139 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+type OrderStatus string
+
+const (
+	StatusPendingNew      = OrderStatus("PENDING_NEW")
+	StatusNew             = OrderStatus("NEW")
+	StatusPartiallyFilled = OrderStatus("PARTIALLY_FILLED")
+	StatusFilled          = OrderStatus("FILLED")
+	StatusPendingCancel   = OrderStatus("PENDING_CANCEL")
+	StatusCanceled        = OrderStatus("CANCELED")
+	StatusRejected        = OrderStatus("REJECTED")
+	StatusExpired         = OrderStatus("EXPIRED")
+)
+
+// orderTransitions lists the statuses an order may move to from each status.
+// Fills may be reported before the acknowledgement, and while a cancel is
+// pending, so those paths are allowed too.
+var orderTransitions = map[OrderStatus][]OrderStatus{
+	StatusPendingNew:      {StatusNew, StatusPartiallyFilled, StatusFilled, StatusCanceled, StatusRejected, StatusExpired},
+	StatusNew:             {StatusPartiallyFilled, StatusFilled, StatusPendingCancel, StatusCanceled, StatusExpired},
+	StatusPartiallyFilled: {StatusPartiallyFilled, StatusFilled, StatusPendingCancel, StatusCanceled, StatusExpired},
+	StatusPendingCancel:   {StatusPartiallyFilled, StatusFilled, StatusCanceled},
+}
+
+func (s OrderStatus) IsDone() bool {
+	switch s {
+	case StatusFilled, StatusCanceled, StatusRejected, StatusExpired:
+		return true
+	}
+	return false
+}
+
+func (s OrderStatus) CanTransit(to OrderStatus) bool {
+	for _, next := range orderTransitions[s] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// NewOrder returns an order waiting to be sent. Limit orders are GTC.
+func NewOrder(id string, exchange string, symbol Symbol, orderType OrderType, side OrderSide, price float64, quantity float64) *Order {
+	now := time.Now()
+	order := &Order{
+		ID:        id,
+		Exchange:  exchange,
+		Symbol:    symbol,
+		OrderType: orderType,
+		Side:      side,
+		Price:     price,
+		Quantity:  quantity,
+		Status:    StatusPendingNew,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if orderType == TypeLimit {
+		order.TimeInForce = GTC
+	}
+	return order
+}
+
+// IsImmediate tells whether the order never rests on the book.
+func (o *Order) IsImmediate() bool {
+	return o.OrderType == TypeLimit && (o.TimeInForce == IOC || o.TimeInForce == FOK)
+}
+
+func (o *Order) IsDone() bool {
+	return o.Status.IsDone()
+}
+
+// Transit moves the order to the given status. Reporting the current status
+// again is accepted and changes nothing.
+func (o *Order) Transit(status OrderStatus, at time.Time) error {
+	if o.Status == "" {
+		o.Status = StatusPendingNew
+	}
+	if o.Status == status {
+		return nil
+	}
+	if !o.Status.CanTransit(status) {
+		return fmt.Errorf("Invalid order transition of %s: %s -> %s", o.ID, o.Status, status)
+	}
+	o.Status = status
+	o.UpdatedAt = at
+	return nil
+}
+
+// Fill adds an execution to the order and moves it to PartiallyFilled or
+// Filled.
+func (o *Order) Fill(qty float64, price float64, commission float64, at time.Time) error {
+	if qty <= 0 {
+		return nil
+	}
+	status := StatusPartiallyFilled
+	if o.ExecutedQty+qty >= o.Quantity {
+		status = StatusFilled
+	}
+	if o.Status != StatusPendingCancel || status == StatusFilled {
+		if err := o.Transit(status, at); err != nil {
+			return err
+		}
+	}
+	o.AvgPrice = (o.AvgPrice*o.ExecutedQty + price*qty) / (o.ExecutedQty + qty)
+	o.ExecutedQty += qty
+	o.Commission += commission
+	o.UpdatedAt = at
+	return nil
+}
+
+// Apply updates the order from an execution report of the exchange.
+func (o *Order) Apply(event *OrderEvent) error {
+	if event.ExchangeOrderID != 0 {
+		o.ExchangeOrderID = event.ExchangeOrderID
+	}
+	if event.CommissionAsset != "" {
+		o.CommissionAsset = event.CommissionAsset
+	}
+	if event.ExecutedQty > o.ExecutedQty {
+		price := event.LastPrice
+		if price <= 0 {
+			price = o.Price
+		}
+		qty := event.ExecutedQty - o.ExecutedQty
+		if err := o.Fill(qty, price, event.Commission, event.Time); err != nil {
+			return err
+		}
+	}
+	if event.Status == StatusPartiallyFilled {
+		return nil
+	}
+	return o.Transit(event.Status, event.Time)
+}
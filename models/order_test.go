@@ -0,0 +1,61 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOrderFill(t *testing.T) {
+	order := NewOrder("order", "test", Symbol{Text: "XRPBTC"}, TypeLimit, SideBuy, 0.0001, 100)
+	if order.Status != StatusPendingNew {
+		t.Fatal("test failed")
+	}
+
+	if order.Transit(StatusNew, time.Now()) != nil {
+		t.Fatal("test failed")
+	}
+
+	if order.Fill(40, 0.0001, 0.04, time.Now()) != nil || order.Status != StatusPartiallyFilled {
+		t.Fatal("test failed")
+	}
+
+	if order.Fill(60, 0.0002, 0.06, time.Now()) != nil || order.Status != StatusFilled {
+		t.Fatal("test failed")
+	}
+
+	if order.ExecutedQty != 100 || order.Commission != 0.1 {
+		t.Fatal("test failed")
+	}
+
+	if order.AvgPrice < 0.000159 || order.AvgPrice > 0.000161 {
+		t.Fatalf("test failed %f", order.AvgPrice)
+	}
+
+	if !order.IsDone() {
+		t.Fatal("test failed")
+	}
+}
+
+func TestOrderInvalidTransition(t *testing.T) {
+	order := NewOrder("order", "test", Symbol{Text: "XRPBTC"}, TypeLimit, SideBuy, 0.0001, 100)
+	order.Transit(StatusFilled, time.Now())
+
+	if order.Transit(StatusCanceled, time.Now()) == nil {
+		t.Fatal("test failed")
+	}
+
+	if order.Transit(StatusFilled, time.Now()) != nil {
+		t.Fatal("test failed")
+	}
+}
+
+func TestOrderApply(t *testing.T) {
+	order := NewOrder("order", "test", Symbol{Text: "XRPBTC"}, TypeLimit, SideBuy, 0.0001, 100)
+	order.Apply(&OrderEvent{Status: StatusNew, ExchangeOrderID: 1})
+	order.Apply(&OrderEvent{Status: StatusPartiallyFilled, ExecutedQty: 30, LastPrice: 0.0001})
+	order.Apply(&OrderEvent{Status: StatusCanceled, ExecutedQty: 30})
+
+	if order.Status != StatusCanceled || order.ExecutedQty != 30 || order.ExchangeOrderID != 1 {
+		t.Fatal("test failed")
+	}
+}
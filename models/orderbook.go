@@ -0,0 +1,84 @@
+package models
+
+import (
+	"time"
+)
+
+type OrderBookLevel struct {
+	Price    float64 `json:"price"`
+	Quantity float64 `json:"quantity"`
+}
+
+type OrderBook struct {
+	Symbol Symbol           `json:"symbol"`
+	Bids   []OrderBookLevel `json:"bids"`
+	Asks   []OrderBookLevel `json:"asks"`
+	Time   time.Time        `json:"time"`
+}
+
+// Buy walks up the asks spending quoteQty and returns the base quantity
+// filled, its volume weighted average price and the worst price reached.
+func (b *OrderBook) Buy(quoteQty float64) (float64, float64, float64) {
+	remaining := quoteQty
+	baseQty := 0.0
+	limitPrice := 0.0
+	for _, level := range b.Asks {
+		if remaining <= 0 {
+			break
+		}
+		limitPrice = level.Price
+		cost := level.Price * level.Quantity
+		if remaining >= cost {
+			baseQty += level.Quantity
+			remaining -= cost
+		} else {
+			baseQty += remaining / level.Price
+			remaining = 0
+		}
+	}
+	if baseQty == 0 {
+		return 0, 0, limitPrice
+	}
+	return baseQty, (quoteQty - remaining) / baseQty, limitPrice
+}
+
+// Sell walks down the bids selling baseQty and returns the quote quantity
+// received, its volume weighted average price and the worst price reached.
+func (b *OrderBook) Sell(baseQty float64) (float64, float64, float64) {
+	remaining := baseQty
+	quoteQty := 0.0
+	limitPrice := 0.0
+	for _, level := range b.Bids {
+		if remaining <= 0 {
+			break
+		}
+		limitPrice = level.Price
+		if remaining >= level.Quantity {
+			quoteQty += level.Price * level.Quantity
+			remaining -= level.Quantity
+		} else {
+			quoteQty += level.Price * remaining
+			remaining = 0
+		}
+	}
+	if baseQty == remaining {
+		return 0, 0, limitPrice
+	}
+	return quoteQty, quoteQty / (baseQty - remaining), limitPrice
+}
+
+func (b *OrderBook) BidQuantity() float64 {
+	quantity := 0.0
+	for _, level := range b.Bids {
+		quantity += level.Quantity
+	}
+	return quantity
+}
+
+func (b *OrderBook) AskQuantity() float64 {
+	quantity := 0.0
+	for _, level := range b.Asks {
+		quantity += level.Quantity
+	}
+	return quantity
+}
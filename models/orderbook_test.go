@@ -0,0 +1,44 @@
+package models
+
+import (
+	"math"
+	"testing"
+)
+
+func TestOrderBookBuy(t *testing.T) {
+	book := &OrderBook{
+		Asks: []OrderBookLevel{
+			{Price: 1.0, Quantity: 10},
+			{Price: 2.0, Quantity: 10},
+		},
+	}
+
+	base, vwap, limit := book.Buy(20)
+	if base != 15 || math.Abs(vwap-20.0/15.0) > 1e-12 || limit != 2.0 {
+		t.Fatal("test failed")
+	}
+
+	base, _, _ = book.Buy(100)
+	if base != 20 {
+		t.Fatal("test failed")
+	}
+}
+
+func TestOrderBookSell(t *testing.T) {
+	book := &OrderBook{
+		Bids: []OrderBookLevel{
+			{Price: 2.0, Quantity: 10},
+			{Price: 1.0, Quantity: 10},
+		},
+	}
+
+	quote, vwap, limit := book.Sell(15)
+	if quote != 25 || math.Abs(vwap-25.0/15.0) > 1e-12 || limit != 1.0 {
+		t.Fatal("test failed")
+	}
+
+	quote, _, _ = book.Sell(0)
+	if quote != 0 {
+		t.Fatal("test failed")
+	}
+}
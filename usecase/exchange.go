@@ -5,6 +5,7 @@ import (
 )
 
 type Exchange interface {
+	Name() string
 	GetFee() float64
 	GetBalances() ([]*models.Balance, error)
 	GetQuotes() []string
@@ -13,5 +14,7 @@ type Exchange interface {
 	GetDepthOnUpdate() chan *models.Depth
 	SendOrder(order *models.Order) error
 	ConfirmOrder(order *models.Order) (float64, error)
+	QueryOrder(order *models.Order) error
 	CancelOrder(order *models.Order) error
+	GetOrderEvents() chan *models.OrderEvent
 }
@@ -0,0 +1,15 @@
+package usecase
+
+import (
+	models "github.com/OopsMouse/arbitgo/models"
+)
+
+// Journal persists the sequences of the trader, their orders, fills and
+// cancels, and the balances, so that they survive a restart. Failing to write
+// to it is logged and never stops trading.
+type Journal interface {
+	RecordSequence(seq *models.Sequence) error
+	RecordOrder(order *models.Order) error
+	RecordBalances(sequenceID string, balances []*models.Balance) error
+	OpenOrders() ([]*models.Order, error)
+}
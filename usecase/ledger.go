@@ -0,0 +1,267 @@
+package usecase
+
+import (
+	"sort"
+	"sync"
+
+	models "github.com/OopsMouse/arbitgo/models"
+	log "github.com/sirupsen/logrus"
+)
+
+type reservation struct {
+	Exchange string
+	Asset    string
+	To       string
+	Quantity float64
+}
+
+// ledger keeps the balances of every venue locally together with the
+// quantity reserved by each in-flight leg, so that concurrent sequences only
+// spend the part of a balance nobody else holds.
+type ledger struct {
+	balances     map[string]*models.Balance
+	reservations map[string]*reservation
+	version      int64
+	touched      map[string]int64
+	lock         *sync.Mutex
+}
+
+func newLedger() *ledger {
+	return &ledger{
+		balances:     map[string]*models.Balance{},
+		reservations: map[string]*reservation{},
+		touched:      map[string]int64{},
+		lock:         new(sync.Mutex),
+	}
+}
+
+func ledgerKey(exchange string, asset string) string {
+	return exchange + ":" + asset
+}
+
+// Version returns the current version of the ledger, to be taken before
+// reading the balances passed to Reconcile.
+func (l *ledger) Version() int64 {
+	defer l.lock.Unlock()
+	l.lock.Lock()
+	return l.version
+}
+
+func (l *ledger) touch(keys ...string) {
+	l.version++
+	for _, key := range keys {
+		l.touched[key] = l.version
+	}
+}
+
+// Reconcile replaces the local balances with the ones of the exchanges, read
+// after the given version. The balance owned is free plus locked. An asset
+// spent or bought by an order in flight, or booked since, is kept as it is
+// because the exchange may already show fills not booked yet. The
+// reservations are kept.
+func (l *ledger) Reconcile(since int64, balances []*models.Balance) {
+	defer l.lock.Unlock()
+	l.lock.Lock()
+
+	kept := map[string]bool{}
+	for key, version := range l.touched {
+		if version > since {
+			kept[key] = true
+		}
+	}
+	for _, r := range l.reservations {
+		if r.To != "" {
+			kept[ledgerKey(r.Exchange, r.Asset)] = true
+			kept[ledgerKey(r.Exchange, r.To)] = true
+		}
+	}
+
+	reconciled := map[string]*models.Balance{}
+	for key, b := range l.balances {
+		if kept[key] {
+			reconciled[key] = b
+		}
+	}
+	for _, b := range balances {
+		key := ledgerKey(b.Exchange, b.Asset)
+		if kept[key] {
+			continue
+		}
+		reconciled[key] = &models.Balance{
+			Exchange: b.Exchange,
+			Asset:    b.Asset,
+			Free:     b.Total,
+			Total:    b.Total,
+		}
+	}
+	l.balances = reconciled
+
+	for id, r := range l.reservations {
+		if free := l.free(r.Exchange, r.Asset); r.Quantity > free*(1+1e-9) {
+			log.Warnf("Reservation %s of %s %s exceeds the free balance : %f > %f", id, r.Exchange, r.Asset, r.Quantity, free)
+		}
+	}
+}
+
+func (l *ledger) free(exchange string, asset string) float64 {
+	if b := l.balances[ledgerKey(exchange, asset)]; b != nil {
+		return b.Free
+	}
+	return 0
+}
+
+func (l *ledger) reserved(exchange string, asset string) float64 {
+	reserved := 0.0
+	for _, r := range l.reservations {
+		if r.Exchange == exchange && r.Asset == asset {
+			reserved += r.Quantity
+		}
+	}
+	return reserved
+}
+
+// Available returns the free balance not reserved by any leg.
+func (l *ledger) Available(exchange string, asset string) float64 {
+	defer l.lock.Unlock()
+	l.lock.Lock()
+	available := l.free(exchange, asset) - l.reserved(exchange, asset)
+	if available < 0 {
+		return 0
+	}
+	return available
+}
+
+// Reserve sets aside up to quantity of the asset for the given id and
+// returns what was reserved.
+func (l *ledger) Reserve(id string, exchange string, asset string, quantity float64) float64 {
+	defer l.lock.Unlock()
+	l.lock.Lock()
+
+	available := l.free(exchange, asset) - l.reserved(exchange, asset)
+	if quantity > available {
+		quantity = available
+	}
+	if quantity <= 0 {
+		return 0
+	}
+
+	r := l.reservations[id]
+	if r == nil {
+		r = &reservation{Exchange: exchange, Asset: asset}
+		l.reservations[id] = r
+	}
+	r.Quantity += quantity
+	return quantity
+}
+
+// Reserved returns the quantity still reserved for the given id.
+func (l *ledger) Reserved(id string) float64 {
+	defer l.lock.Unlock()
+	l.lock.Lock()
+	if r := l.reservations[id]; r != nil {
+		return r.Quantity
+	}
+	return 0
+}
+
+// Expect marks the reservation as spent by an order in flight which buys
+// the given asset.
+func (l *ledger) Expect(id string, asset string) {
+	defer l.lock.Unlock()
+	l.lock.Lock()
+	if r := l.reservations[id]; r != nil {
+		r.To = asset
+		l.touch(ledgerKey(r.Exchange, r.Asset), ledgerKey(r.Exchange, asset))
+	}
+}
+
+// Release returns what is left of the reservation to the available balance.
+func (l *ledger) Release(id string) {
+	defer l.lock.Unlock()
+	l.lock.Lock()
+	if r := l.reservations[id]; r != nil && r.To != "" {
+		l.touch(ledgerKey(r.Exchange, r.Asset), ledgerKey(r.Exchange, r.To))
+	}
+	delete(l.reservations, id)
+}
+
+// Convert books a fill of the reservation: spent leaves the reserved asset
+// and received is credited to the asset bought, reserved for nextID unless it
+// is empty.
+func (l *ledger) Convert(id string, spent float64, asset string, received float64, nextID string) {
+	defer l.lock.Unlock()
+	l.lock.Lock()
+
+	r := l.reservations[id]
+	if r == nil {
+		return
+	}
+
+	r.Quantity -= spent
+	if r.Quantity < 0 {
+		r.Quantity = 0
+	}
+	l.add(r.Exchange, r.Asset, -spent)
+	l.add(r.Exchange, asset, received)
+	l.touch(ledgerKey(r.Exchange, r.Asset), ledgerKey(r.Exchange, asset))
+
+	if nextID != "" && received > 0 {
+		next := l.reservations[nextID]
+		if next == nil {
+			next = &reservation{Exchange: r.Exchange, Asset: asset}
+			l.reservations[nextID] = next
+		}
+		next.Quantity += received
+	}
+}
+
+// Book books a fill which spent no reservation, such as one of an order a
+// previous run left open.
+func (l *ledger) Book(exchange string, spentAsset string, spent float64, asset string, received float64) {
+	defer l.lock.Unlock()
+	l.lock.Lock()
+	l.add(exchange, spentAsset, -spent)
+	l.add(exchange, asset, received)
+	l.touch(ledgerKey(exchange, spentAsset), ledgerKey(exchange, asset))
+}
+
+func (l *ledger) add(exchange string, asset string, quantity float64) {
+	key := ledgerKey(exchange, asset)
+	b := l.balances[key]
+	if b == nil {
+		b = &models.Balance{Exchange: exchange, Asset: asset}
+		l.balances[key] = b
+	}
+	b.Free += quantity
+	b.Total += quantity
+}
+
+func (l *ledger) Balance(exchange string, asset string) *models.Balance {
+	defer l.lock.Unlock()
+	l.lock.Lock()
+	b := l.balances[ledgerKey(exchange, asset)]
+	if b == nil {
+		return nil
+	}
+	copied := *b
+	return &copied
+}
+
+// Balances returns a copy of the local balances ordered by venue and asset.
+func (l *ledger) Balances() []*models.Balance {
+	defer l.lock.Unlock()
+	l.lock.Lock()
+
+	keys := []string{}
+	for key := range l.balances {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	balances := []*models.Balance{}
+	for _, key := range keys {
+		copied := *l.balances[key]
+		balances = append(balances, &copied)
+	}
+	return balances
+}
@@ -0,0 +1,85 @@
+package usecase
+
+import (
+	"math"
+	"testing"
+
+	"github.com/OopsMouse/arbitgo/models"
+)
+
+func TestLedgerReserve(t *testing.T) {
+	l := newLedger()
+	l.Reconcile(l.Version(), []*models.Balance{
+		{Exchange: "test", Asset: "BTC", Free: 1, Total: 1},
+	})
+
+	if l.Reserve("a", "test", "BTC", 0.6) != 0.6 {
+		t.Fatal("test failed")
+	}
+
+	if math.Abs(l.Reserve("b", "test", "BTC", 0.6)-0.4) > 1e-9 {
+		t.Fatal("test failed")
+	}
+
+	if l.Available("test", "BTC") != 0 {
+		t.Fatal("test failed")
+	}
+
+	l.Release("b")
+	if math.Abs(l.Available("test", "BTC")-0.4) > 1e-9 {
+		t.Fatal("test failed")
+	}
+}
+
+func TestLedgerConvert(t *testing.T) {
+	l := newLedger()
+	l.Reconcile(l.Version(), []*models.Balance{
+		{Exchange: "test", Asset: "BTC", Free: 1, Total: 1},
+	})
+
+	l.Reserve("a", "test", "BTC", 0.5)
+	l.Convert("a", 0.5, "XRP", 5000, "b")
+	l.Release("a")
+
+	if math.Abs(l.Balance("test", "BTC").Free-0.5) > 1e-9 || l.Balance("test", "XRP").Free != 5000 {
+		t.Fatal("test failed")
+	}
+
+	if l.Reserved("b") != 5000 || l.Available("test", "XRP") != 0 {
+		t.Fatal("test failed")
+	}
+
+	l.Reconcile(l.Version(), []*models.Balance{
+		{Exchange: "test", Asset: "BTC", Free: 0.5, Total: 0.5},
+		{Exchange: "test", Asset: "XRP", Free: 6000, Total: 6000},
+	})
+
+	if l.Reserved("b") != 5000 || l.Available("test", "XRP") != 1000 {
+		t.Fatal("test failed")
+	}
+}
+
+func TestReserveSequence(t *testing.T) {
+	depthes := createPricedDepthes([][]interface{}{
+		{"XRP", "BTC", 0.0001, 0.000101},
+	})
+	trader := newTestTrader(testOptions{depthes: depthes, balances: []*models.Balance{
+		{Asset: "BTC", Free: 1, Total: 1},
+	}})
+
+	first := &models.Sequence{ID: "first", Symbol: depthes[0].Symbol, Side: models.SideBuy, From: "BTC", To: "BTC", Target: 0.6}
+	second := &models.Sequence{ID: "second", Symbol: depthes[0].Symbol, Side: models.SideBuy, From: "BTC", To: "BTC", Target: 0.3}
+	third := &models.Sequence{ID: "third", Symbol: depthes[0].Symbol, Side: models.SideBuy, From: "BTC", To: "BTC", Target: 0.3}
+
+	if !trader.reserveSequence(first, false) || !trader.reserveSequence(second, false) {
+		t.Fatal("test failed")
+	}
+
+	if trader.reserveSequence(third, false) {
+		t.Fatal("test failed")
+	}
+
+	if trader.ledger.Reserved(legID(third)) != 0 {
+		t.Fatal("test failed")
+	}
+}
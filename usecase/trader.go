@@ -1,9 +1,12 @@
 package usecase
 
 import (
+	"context"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
+	"time"
 
 	models "github.com/OopsMouse/arbitgo/models"
 	"github.com/OopsMouse/arbitgo/util"
@@ -11,45 +14,149 @@ import (
 )
 
 type Trader struct {
-	Exchange   Exchange
-	cache      *util.DepthCache
-	balances   []*models.Balance
-	serverHost *string
-	positions  *util.Set
+	Exchange        Exchange
+	ConfirmTimeout  time.Duration
+	HomeAssets      map[string]float64
+	ParallelLegs    bool
+	TimeInForce     models.TimeInForce
+	Recovery        RecoveryConfig
+	Rebalancer      RebalanceConfig
+	Risk            RiskConfig
+	QuoteMaxAge     time.Duration
+	SymbolMaxAge    map[string]time.Duration
+	Clock           func() time.Time
+	ShutdownTimeout time.Duration
+	Journal         Journal
+	exchanges       map[string]Exchange
+	cache           *util.DepthCache
+	caches          map[string]*util.DepthCache
+	index           *cycleIndex
+	ledger          *ledger
+	serverHost      *string
+	positions       *util.Set
+	recoveries      *util.Set
+	report          *Report
+	waiters         map[string]chan *models.OrderEvent
+	orders          map[string]*models.Order
+	listening       map[string]bool
+	waiterLock      *sync.Mutex
+	risk            *riskState
+	converting      int32
+	recoverQueued   int32
+	pausedUntil     int64
+	inflight        int64
+	planned         chan struct{}
 }
 
 func NewTrader(ex Exchange, serverHost *string) *Trader {
+	cache := util.NewDepthCache()
 	return &Trader{
-		Exchange:   ex,
-		cache:      util.NewDepthCache(),
-		balances:   []*models.Balance{},
-		positions:  util.NewSet(),
-		serverHost: serverHost,
+		Exchange:        ex,
+		ConfirmTimeout:  DefaultConfirmTimeout,
+		Recovery:        DefaultRecoveryConfig,
+		Rebalancer:      DefaultRebalanceConfig,
+		Risk:            DefaultRiskConfig,
+		TimeInForce:     models.IOC,
+		QuoteMaxAge:     util.DefaultDepthMaxAge,
+		SymbolMaxAge:    map[string]time.Duration{},
+		ShutdownTimeout: DefaultShutdownTimeout,
+		exchanges:       map[string]Exchange{ex.Name(): ex},
+		cache:           cache,
+		caches:          map[string]*util.DepthCache{ex.Name(): cache},
+		index:           newCycleIndex(ex.GetSymbols(), ex.GetQuotes(), MAX_SEQUENCE_SIZE),
+		ledger:          newLedger(),
+		positions:       util.NewSet(),
+		recoveries:      util.NewSet(),
+		report:          NewReport(),
+		serverHost:      serverHost,
+		waiters:         map[string]chan *models.OrderEvent{},
+		orders:          map[string]*models.Order{},
+		listening:       map[string]bool{},
+		waiterLock:      new(sync.Mutex),
+		risk:            newRiskState(),
 	}
 }
 
 const Worker = 1
 
+const DefaultConfirmTimeout = 60 * time.Second
+
 func (trader *Trader) Run() {
 	log.Info("Starting Trader ....")
 
+	// The balances are loaded once the orders left open are settled, so that
+	// none of their fills is counted twice.
+	trader.restoreOrders()
+
 	trader.PrintBalanceOfBigAssets()
 
-	depch := trader.depthSubscriber()
-	seqch := trader.runTrader()
+	ctx, cancel := context.WithCancel(context.Background())
+	depch := trader.depthSubscriber(ctx)
+	seqch := trader.runTrader(ctx)
 
 	for i := 0; i < Worker; i++ {
-		go trader.runAnalyzer(depch, seqch)
+		go trader.runAnalyzer(ctx, depch, seqch)
 	}
 
+	trader.runRebalancer(ctx)
+	trader.runReconciler(ctx)
+	trader.recoverRestored(ctx)
+
 	interrupt := make(chan os.Signal, 1)
-	signal.Notify(interrupt, os.Interrupt, os.Kill, syscall.SIGTERM)
+	signal.Notify(interrupt, os.Interrupt, os.Kill, syscall.SIGTERM, syscall.SIGUSR1, syscall.SIGUSR2)
 	for {
 		select {
 		case kill := <-interrupt:
 			log.Info("Got signal : ", kill)
+			switch kill {
+			case syscall.SIGUSR1:
+				trader.Kill("manual")
+				continue
+			case syscall.SIGUSR2:
+				trader.Resume()
+				continue
+			}
 			log.Info("Stopping trader")
+			cancel()
+			trader.shutdown()
 			return
 		}
 	}
 }
+
+// Backtest runs the pipeline until every depth feed is closed, executing each
+// sequence before the next depth is analyzed, and returns the report.
+func (trader *Trader) Backtest() *Report {
+	log.Info("Starting Backtest ....")
+
+	trader.PrintBalanceOfBigAssets()
+
+	ctx := context.Background()
+	depch := trader.depthSubscriber(ctx)
+	seqch := make(chan *models.Sequence)
+	done := make(chan struct{})
+	// The analyzer waits for each sequence to be planned, so that the next
+	// depth is analyzed against its reservations whatever the scheduling.
+	trader.planned = make(chan struct{})
+
+	go func() {
+		defer close(done)
+		for seq := range seqch {
+			parallel, ok := trader.planSequence(seq)
+			trader.planned <- struct{}{}
+			if !ok {
+				trader.report.skipped(seq)
+				continue
+			}
+			trader.runSequence(seq, parallel)
+		}
+	}()
+
+	trader.runAnalyzer(ctx, depch, seqch)
+	close(seqch)
+	<-done
+
+	trader.LoadBalances()
+	trader.report.summarize(trader.ledger.Balances(), trader.QuoteStats())
+	return trader.report
+}
@@ -1,205 +1,159 @@
 package usecase
 
 import (
+	"context"
+
 	models "github.com/OopsMouse/arbitgo/models"
-	"github.com/OopsMouse/arbitgo/util"
+	"github.com/rs/xid"
 	log "github.com/sirupsen/logrus"
 )
 
-func (trader *Trader) runAnalyzer(depch chan *models.Depth, seqch chan *models.Sequence) {
+func (trader *Trader) runAnalyzer(ctx context.Context, depch chan *models.Depth, seqch chan *models.Sequence) {
 	for {
-		depth := <-depch
-		bigAssets := trader.BigAssets()
-		renewAsset := depth.BaseAsset
+		depth, ok := nextDepth(ctx, depch)
+		if !ok {
+			return
+		}
 
-		assets := []string{}
-		for _, a := range bigAssets {
-			if !trader.isRunningPosition(a) {
-				assets = append(assets, a)
+		if trader.killed() {
+			continue
+		}
+
+		if trader.isCrossMode() {
+			if seq := trader.bestOfCrossSequence(depth); seq != nil {
+				trader.emitSequence(seqch, seq)
 			}
 		}
 
-		for _, a := range assets {
-			depthes := trader.getDepthes(a, renewAsset)
-			balance := trader.GetBalance(a).Free
+		if !trader.isPrimary(depth.Exchange) {
+			continue
+		}
 
-			func(asset string, depthes []*models.Depth, balance float64) {
-				seq := trader.bestOfSequence(asset, asset, depthes, balance)
+		bigAssets := trader.BigAssets()
+		renewAsset := depth.BaseAsset
 
-				if seq == nil {
-					return
-				}
+		for _, a := range bigAssets {
+			balance := trader.capSequence(a, trader.GetAvailable("", a))
+
+			var seq *models.Sequence
+			if trader.index.Include(a, depth.Symbol) {
+				seq = trader.bestOfIndexedCycle(a, depth.Symbol, balance)
+			} else {
+				depthes := trader.getDepthes(a, renewAsset)
+				seq = trader.bestOfCycle(a, depthes, balance)
+			}
 
-				seqch <- seq
-			}(a, depthes, balance)
+			if seq == nil {
+				continue
+			}
+
+			trader.emitSequence(seqch, seq)
 		}
 	}
 }
 
-func (trader *Trader) bestOfSequence(from string, to string, depthes []*models.Depth, targetQuantity float64) *models.Sequence {
+func nextDepth(ctx context.Context, depch chan *models.Depth) (*models.Depth, bool) {
+	select {
+	case depth, ok := <-depch:
+		return depth, ok
+	case <-ctx.Done():
+		return nil, false
+	}
+}
 
-	symbols := []string{}
-	for _, s := range depthes {
-		symbols = append(symbols, s.Symbol.String())
+func (trader *Trader) emitSequence(seqch chan *models.Sequence, seq *models.Sequence) {
+	id := xid.New().String()
+	for s := seq; s != nil; s = s.Next {
+		s.ID = id
 	}
+	trader.report.detected(seq)
+	trader.journalSequence(seq)
+	seqch <- seq
+	if trader.planned != nil {
+		<-trader.planned
+	}
+}
 
-	log.Debug("Symboles : ", symbols)
+const MAX_SEQUENCE_SIZE = 4
 
-	seqes := unifySequences(newSequences(from, to, depthes))
+const MIN_SCORE = 0.0001
 
-	log.Debug("Sequences Count : ", len(seqes))
+func (trader *Trader) bestOfIndexedCycle(from string, symbol models.Symbol, targetQuantity float64) *models.Sequence {
+	fee := trader.Exchange.GetFee()
+	cycles := trader.index.Get(from, symbol)
 
-	if len(seqes) == 0 {
-		return nil
-	}
+	log.Debug("Cycles Count : ", len(cycles))
 
 	maxScore := 0.0
 	var seqOfMaxScore *models.Sequence
-	for _, seq := range seqes {
+	for _, c := range cycles {
+		rate, ok := c.rate(trader.cache, fee)
+		if !ok || rate-1 <= MIN_SCORE {
+			continue
+		}
+		seq := c.toSequence(trader.cache)
+		if seq == nil {
+			continue
+		}
 		score := trader.scoreOfSequence(seq, targetQuantity)
-		if score > 0.0001 && score > maxScore {
+		if score > MIN_SCORE && score > maxScore {
 			maxScore = score
 			seqOfMaxScore = seq
 		}
 	}
 
-	return seqOfMaxScore
-}
-
-const MAX_SEQUENCE_SIZE = 4
+	if seqOfMaxScore != nil {
+		seqOfMaxScore.Score = maxScore
+	}
 
-func newSequences(from string, to string, depthes []*models.Depth) []*models.Sequence {
-	return _newSequences(from, to, depthes, 1)
+	return seqOfMaxScore
 }
 
-func _newSequences(from string, to string, depthes []*models.Depth, seqDepth int) []*models.Sequence {
-	sequences := []*models.Sequence{}
+func (trader *Trader) bestOfCycle(from string, depthes []*models.Depth, targetQuantity float64) *models.Sequence {
+	graph := newCurrencyGraph(depthes, trader.Exchange.GetFee())
+	cycles := graph.searchCycles(from, MAX_SEQUENCE_SIZE)
 
-	if seqDepth > MAX_SEQUENCE_SIZE {
-		return sequences
-	}
+	log.Debug("Cycles Count : ", len(cycles))
 
-	for i, depth := range depthes {
-
-		if depth.QuoteAsset == from && depth.BaseAsset == to {
-			sequences = append(sequences, &models.Sequence{
-				Symbol:   depth.Symbol,
-				Side:     models.SideBuy,
-				From:     from,
-				To:       to,
-				Price:    depth.AskPrice,
-				Quantity: depth.AskQty,
-				Src:      depth,
-			})
-		} else if depth.QuoteAsset == to && depth.BaseAsset == from {
-			sequences = append(sequences, &models.Sequence{
-				Symbol:   depth.Symbol,
-				Side:     models.SideSell,
-				From:     from,
-				To:       to,
-				Price:    depth.BidPrice,
-				Quantity: depth.BidQty,
-				Src:      depth,
-			})
-		} else {
-			if depth.QuoteAsset == from || depth.BaseAsset == from {
-				var nextFrom string
-				var side models.OrderSide
-				var price float64
-				var quantity float64
-				if depth.QuoteAsset == from {
-					nextFrom = depth.BaseAsset
-					side = models.SideBuy
-					price = depth.AskPrice
-					quantity = depth.AskQty
-				} else {
-					nextFrom = depth.QuoteAsset
-					side = models.SideSell
-					price = depth.BidPrice
-					quantity = depth.BidQty
-				}
-				for _, next := range _newSequences(nextFrom, to, util.Delete(depthes, i), seqDepth+1) {
-					if depth.Symbol.Equal(next.Symbol) {
-						continue
-					}
-					sequences = append(sequences, &models.Sequence{
-						Symbol:   depth.Symbol,
-						Side:     side,
-						From:     from,
-						To:       to,
-						Price:    price,
-						Quantity: quantity,
-						Src:      depth,
-						Next:     next,
-					})
-				}
-			}
+	maxScore := 0.0
+	var seqOfMaxScore *models.Sequence
+	for _, cycle := range cycles {
+		seq := cycleToSequence(cycle)
+		score := trader.scoreOfSequence(seq, targetQuantity)
+		if score > MIN_SCORE && score > maxScore {
+			maxScore = score
+			seqOfMaxScore = seq
 		}
 	}
 
-	return sequences
-}
-
-func unifySequences(seqes []*models.Sequence) []*models.Sequence {
-	unifySeqes := []*models.Sequence{}
-	seqStrings := []string{}
-	for _, seq := range seqes {
-		s := seq
-		seqString := ""
-		for {
-			seqString += s.Symbol.String()
-			if s.Next != nil {
-				s = s.Next
-				continue
-			}
-			break
-		}
-		if util.Include(seqStrings, seqString) {
-			continue
-		}
-		seqStrings = append(seqStrings, seqString)
-		unifySeqes = append(unifySeqes, seq)
+	if seqOfMaxScore != nil {
+		seqOfMaxScore.Score = maxScore
 	}
-	return unifySeqes
+
+	return seqOfMaxScore
 }
 
 func (trader *Trader) scoreOfSequence(sequence *models.Sequence, targetQuantity float64) float64 {
-	from := sequence.From
-	balance := trader.GetBalance(from).Free
-	fee := trader.Exchange.GetFee()
+	quantity := trader.sizeOfSequence(sequence, targetQuantity)
+	if quantity <= 0 {
+		return 0
+	}
 
-	s := sequence
-	currentQuantity := balance
-	currentAsset := from
+	result, err := trader.simulateSequence(sequence, quantity)
+	if err != nil {
+		return 0
+	}
 
 	log.Debug("--------------------------------------------")
-	log.Debugf("%s:%f", currentAsset, currentQuantity)
-	for {
-		s.Target = targetQuantity
-
-		currentQuantity *= (1 - fee)
-
-		if s.Side == models.SideBuy {
-			log.Debugf(" %s, BUY, %f -> ", s.Symbol, s.Price)
-			currentAsset = s.Symbol.BaseAsset
-			currentQuantity = util.Floor(currentQuantity/s.Price, s.Symbol.StepSize)
-		} else {
-			log.Debugf(" %s, SELL, %f -> ", s.Symbol, s.Price)
-			currentAsset = s.Symbol.QuoteAsset
-			currentQuantity = util.Floor(currentQuantity, s.Symbol.StepSize) * s.Price
-		}
-		log.Debugf("%s:%f", currentAsset, currentQuantity)
-
-		if s.Next == nil {
-			break
-		}
-
-		s = s.Next
+	log.Debugf("%s:%f", sequence.From, quantity)
+	for s := sequence; s != nil; s = s.Next {
+		log.Debugf(" %s, %s, %f, %f", s.Symbol, s.Side, s.Price, s.Target)
 	}
-	log.Debugf("Rate : %f", (currentQuantity-targetQuantity)/targetQuantity)
+	log.Debugf("%s:%f", sequence.To, result)
+	log.Debugf("Rate : %f", (result-quantity)/quantity)
 	log.Debug("--------------------------------------------")
 
-	return (currentQuantity - targetQuantity) / targetQuantity
+	return (result - quantity) / quantity
 }
 
 func (trader *Trader) PrintSequence(seq *models.Sequence) {
@@ -1,6 +1,7 @@
 package usecase
 
 import (
+	"context"
 	"testing"
 
 	"github.com/OopsMouse/arbitgo/models"
@@ -30,34 +31,6 @@ func TestDeleteDepthes(t *testing.T) {
 	}
 }
 
-func TestNewSequence1(t *testing.T) {
-	symbols := [][]string{
-		{"BNB", "BTC"},
-	}
-	seqes := newSequences("BNB", "BTC", createDepthes(symbols))
-
-	if len(seqes) != 1 {
-		t.Fatal("test failed")
-	}
-
-	if seqes[0].From != "BNB" || seqes[0].To != "BTC" {
-		t.Fatal("test failed")
-	}
-}
-
-func TestNewSequence2(t *testing.T) {
-	symbols := [][]string{
-		{"XRP", "BTC"},
-		{"XRP", "BNB"},
-		{"BNB", "BTC"},
-	}
-	seqes := newSequences("BTC", "BTC", createDepthes(symbols))
-
-	if len(seqes) != 2 {
-		t.Fatal("test failed")
-	}
-}
-
 func description(seq *models.Sequence) string {
 	desc := ""
 	s := seq
@@ -88,3 +61,26 @@ func createDepthes(symbols [][]string) []*models.Depth {
 	}
 	return depthes
 }
+
+func TestDepthSubscriberSkipsRejected(t *testing.T) {
+	depthes := createPricedDepthes([][]interface{}{
+		{"XRP", "BTC", 0.000099, 0.0001},
+		{"XRP", "BTC", 0.000098, 0.0001},
+	})
+	depthes[0].UpdateID = 2
+	depthes[1].UpdateID = 1
+	trader := NewTrader(&testExchange{
+		name:    "test",
+		fee:     0.001,
+		depthes: map[string]*models.Depth{},
+		feed:    depthes,
+	}, nil)
+
+	forwarded := []*models.Depth{}
+	for depth := range trader.depthSubscriber(context.Background()) {
+		forwarded = append(forwarded, depth)
+	}
+	if len(forwarded) != 1 || forwarded[0] != depthes[0] {
+		t.Fatal("test failed")
+	}
+}
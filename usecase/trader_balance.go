@@ -1,25 +1,113 @@
 package usecase
 
 import (
+	"context"
+	"time"
+
 	"github.com/OopsMouse/arbitgo/models"
 	log "github.com/sirupsen/logrus"
 )
 
+const ReconcileInterval = 30 * time.Second
+
 func (trader *Trader) LoadBalances() {
-	balances, err := trader.Exchange.GetBalances()
-	if err != nil {
-		return
+	since := trader.ledger.Version()
+	balances := []*models.Balance{}
+	for _, name := range trader.exchangeNames() {
+		bs, err := trader.exchanges[name].GetBalances()
+		if err != nil {
+			return
+		}
+		for _, b := range bs {
+			balances = append(balances, &models.Balance{
+				Exchange: name,
+				Asset:    b.Asset,
+				Free:     b.Free,
+				Total:    b.Total,
+			})
+		}
 	}
-	trader.balances = balances
+	trader.ledger.Reconcile(since, balances)
+}
+
+// runReconciler reloads the balances periodically so that the ledger does not
+// drift from the exchanges while no order is sent.
+func (trader *Trader) runReconciler(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(ReconcileInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				trader.LoadBalances()
+				trader.journalBalances("")
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
 }
 
+func legID(seq *models.Sequence) string {
+	return seq.ID + ":" + seq.Exchange + ":" + seq.From
+}
+
+// reserveSequence reserves the input of every leg not fed by the previous
+// one, which is the head and any leg on another venue, or of every leg when
+// they run in parallel. It reserves all of them or none.
+func (trader *Trader) reserveSequence(seq *models.Sequence, parallel bool) bool {
+	reserved := []string{}
+	var prev *models.Sequence
+	for s := seq; s != nil; prev, s = s, s.Next {
+		if !parallel && prev != nil && prev.Exchange == s.Exchange {
+			continue
+		}
+		id := legID(s)
+		reserved = append(reserved, id)
+		exchange := trader.exchangeOf(s.Exchange).Name()
+		want := s.Target
+		if want <= 0 {
+			want = trader.ledger.Available(exchange, s.From)
+		}
+		got := trader.ledger.Reserve(id, exchange, s.From, want)
+		if got <= 0 || got < want*(1-1e-9) {
+			for _, id := range reserved {
+				trader.ledger.Release(id)
+			}
+			return false
+		}
+	}
+	return true
+}
+
+// reserveLeg returns the quantity reserved for the leg, reserving it first
+// for sequences which did not go through reserveSequence.
+func (trader *Trader) reserveLeg(seq *models.Sequence) float64 {
+	id := legID(seq)
+	if reserved := trader.ledger.Reserved(id); reserved > 0 {
+		return reserved
+	}
+	exchange := trader.exchangeOf(seq.Exchange).Name()
+	want := seq.Target
+	if want <= 0 {
+		want = trader.ledger.Available(exchange, seq.From)
+	}
+	return trader.ledger.Reserve(id, exchange, seq.From, want)
+}
+
+// BigAssets returns the home assets whose unreserved balance is large enough
+// to start a sequence with.
 func (trader *Trader) BigAssets() []string {
 	symbols := trader.Exchange.GetSymbols()
 	bigAssets := []string{}
-	for _, balance := range trader.balances {
+	for _, balance := range trader.ledger.Balances() {
+		if balance.Exchange != trader.Exchange.Name() || !trader.isHomeAsset(balance.Asset) {
+			continue
+		}
+		available := trader.ledger.Available(balance.Exchange, balance.Asset)
 		for _, symbol := range symbols {
 			if symbol.BaseAsset == balance.Asset &&
-				balance.Free > symbol.MinQty {
+				available > symbol.MinQty {
 				bigAssets = append(bigAssets, balance.Asset)
 				break
 			}
@@ -29,12 +117,16 @@ func (trader *Trader) BigAssets() []string {
 }
 
 func (trader *Trader) GetBalance(asset string) *models.Balance {
-	for _, balance := range trader.balances {
-		if balance.Asset == asset {
-			return balance
-		}
-	}
-	return nil
+	return trader.GetBalanceOf(trader.Exchange.Name(), asset)
+}
+
+func (trader *Trader) GetBalanceOf(exchange string, asset string) *models.Balance {
+	return trader.ledger.Balance(trader.exchangeOf(exchange).Name(), asset)
+}
+
+// GetAvailable returns the balance of the asset not reserved by any leg.
+func (trader *Trader) GetAvailable(exchange string, asset string) float64 {
+	return trader.ledger.Available(trader.exchangeOf(exchange).Name(), asset)
 }
 
 func (trader *Trader) PrintBalances() {
@@ -42,8 +134,12 @@ func (trader *Trader) PrintBalances() {
 
 	log.Info("----------------- Balances -----------------")
 
-	for _, balance := range trader.balances {
-		log.Info(balance.Asset, " : ", balance.Total)
+	for _, balance := range trader.ledger.Balances() {
+		if len(trader.exchanges) > 1 {
+			log.Info(balance.Exchange, " ", balance.Asset, " : ", balance.Total)
+		} else {
+			log.Info(balance.Asset, " : ", balance.Total)
+		}
 	}
 
 	log.Info("--------------------------------------------")
@@ -0,0 +1,165 @@
+package usecase
+
+import (
+	"math"
+	"sort"
+
+	models "github.com/OopsMouse/arbitgo/models"
+	"github.com/OopsMouse/arbitgo/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// AddExchange registers another venue. Its depthes and balances are kept apart
+// from the primary exchange and are only used for cross-exchange sequences.
+func (trader *Trader) AddExchange(ex Exchange) {
+	trader.exchanges[ex.Name()] = ex
+	trader.caches[ex.Name()] = util.NewDepthCache()
+}
+
+func (trader *Trader) exchangeNames() []string {
+	names := []string{trader.Exchange.Name()}
+	others := []string{}
+	for name := range trader.exchanges {
+		if name != trader.Exchange.Name() {
+			others = append(others, name)
+		}
+	}
+	sort.Strings(others)
+	return append(names, others...)
+}
+
+func (trader *Trader) exchangeOf(name string) Exchange {
+	if ex, ok := trader.exchanges[name]; ok {
+		return ex
+	}
+	return trader.Exchange
+}
+
+func (trader *Trader) isPrimary(name string) bool {
+	return name == "" || name == trader.Exchange.Name()
+}
+
+func (trader *Trader) isCrossMode() bool {
+	return len(trader.exchanges) > 1
+}
+
+func (trader *Trader) positionOf(seq *models.Sequence) string {
+	if trader.isPrimary(seq.Exchange) {
+		return seq.From
+	}
+	return seq.Exchange + ":" + seq.From
+}
+
+// bestOfCrossSequence compares the updated depth with the same symbol on every
+// other venue and returns a sequence buying on the cheaper venue and selling on
+// the dearer one, each leg using the inventory already held on its venue. The
+// quotes are read from the caches, so that none is traded on which is stale or
+// suspect.
+func (trader *Trader) bestOfCrossSequence(updated *models.Depth) *models.Sequence {
+	cache := trader.caches[updated.Exchange]
+	if cache == nil {
+		return nil
+	}
+	depth := cache.Get(updated.Symbol)
+	if depth == nil {
+		return nil
+	}
+
+	maxScore := 0.0
+	var seqOfMaxScore *models.Sequence
+
+	for _, name := range trader.exchangeNames() {
+		if name == depth.Exchange {
+			continue
+		}
+		other := trader.caches[name].Get(depth.Symbol)
+		if other == nil {
+			continue
+		}
+
+		for _, pair := range [][]*models.Depth{{depth, other}, {other, depth}} {
+			seq := trader.newCrossSequence(pair[0], pair[1])
+			if seq == nil {
+				continue
+			}
+			score := trader.scoreOfCrossSequence(seq)
+			if score > MIN_SCORE && score > maxScore {
+				maxScore = score
+				seqOfMaxScore = seq
+			}
+		}
+	}
+
+	if seqOfMaxScore != nil {
+		seqOfMaxScore.Score = maxScore
+	}
+
+	return seqOfMaxScore
+}
+
+func (trader *Trader) newCrossSequence(buy *models.Depth, sell *models.Depth) *models.Sequence {
+	if buy.AskPrice <= 0 || sell.BidPrice <= 0 {
+		return nil
+	}
+
+	symbol := buy.Symbol
+	buyFee := trader.exchangeOf(buy.Exchange).GetFee()
+
+	quoteBalance := trader.GetBalanceOf(buy.Exchange, symbol.QuoteAsset)
+	baseBalance := trader.GetBalanceOf(sell.Exchange, symbol.BaseAsset)
+	if quoteBalance == nil || baseBalance == nil {
+		return nil
+	}
+
+	quantity := math.Min(buy.AskQty, sell.BidQty)
+	quantity = math.Min(quantity, trader.GetAvailable(buy.Exchange, symbol.QuoteAsset)*(1-buyFee)/buy.AskPrice)
+	quantity = math.Min(quantity, trader.GetAvailable(sell.Exchange, symbol.BaseAsset))
+	if symbol.MaxQty > 0 {
+		quantity = math.Min(quantity, symbol.MaxQty)
+	}
+	quantity = util.Floor(quantity, symbol.StepSize)
+
+	if quantity <= 0 ||
+		quantity < symbol.MinQty ||
+		quantity*buy.AskPrice < symbol.MinNotional ||
+		quantity*sell.BidPrice < symbol.MinNotional {
+		return nil
+	}
+
+	return &models.Sequence{
+		Exchange: buy.Exchange,
+		Symbol:   symbol,
+		Side:     models.SideBuy,
+		From:     symbol.QuoteAsset,
+		To:       symbol.QuoteAsset,
+		Price:    buy.AskPrice,
+		Quantity: buy.AskQty,
+		Target:   quantity * buy.AskPrice,
+		Src:      buy,
+		Next: &models.Sequence{
+			Exchange: sell.Exchange,
+			Symbol:   symbol,
+			Side:     models.SideSell,
+			From:     symbol.BaseAsset,
+			To:       symbol.QuoteAsset,
+			Price:    sell.BidPrice,
+			Quantity: sell.BidQty,
+			Target:   quantity,
+			Src:      sell,
+		},
+	}
+}
+
+func (trader *Trader) scoreOfCrossSequence(seq *models.Sequence) float64 {
+	buy := seq
+	sell := seq.Next
+	buyFee := trader.exchangeOf(buy.Exchange).GetFee()
+	sellFee := trader.exchangeOf(sell.Exchange).GetFee()
+
+	cost := buy.Target
+	proceeds := sell.Target * sell.Price * (1 - buyFee) * (1 - sellFee)
+
+	log.Debugf("Cross %s : %s %f -> %s %f", seq.Symbol, buy.Exchange, buy.Price, sell.Exchange, sell.Price)
+
+	return (proceeds - cost) / cost
+}
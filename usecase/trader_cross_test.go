@@ -0,0 +1,182 @@
+package usecase
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/OopsMouse/arbitgo/infrastructure"
+	"github.com/OopsMouse/arbitgo/models"
+)
+
+type recordedExchange struct {
+	name    string
+	depthes map[string]*models.Depth
+}
+
+func newRecordedExchange(name string, file string) (*recordedExchange, error) {
+	bytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var depthes []*models.Depth
+	err = json.Unmarshal(bytes, &depthes)
+	if err != nil {
+		return nil, err
+	}
+	ex := &recordedExchange{
+		name:    name,
+		depthes: map[string]*models.Depth{},
+	}
+	for _, d := range depthes {
+		if d.Exchange == name {
+			d.Time = time.Now()
+			ex.depthes[d.Symbol.String()] = d
+		}
+	}
+	return ex, nil
+}
+
+func (ex *recordedExchange) Name() string {
+	return ex.name
+}
+
+func (ex *recordedExchange) GetFee() float64 {
+	return 0.001
+}
+
+func (ex *recordedExchange) GetBalances() ([]*models.Balance, error) {
+	return []*models.Balance{}, nil
+}
+
+func (ex *recordedExchange) GetQuotes() []string {
+	return []string{"BTC"}
+}
+
+func (ex *recordedExchange) GetSymbols() []models.Symbol {
+	symbols := []models.Symbol{}
+	for _, d := range ex.depthes {
+		symbols = append(symbols, d.Symbol)
+	}
+	return symbols
+}
+
+func (ex *recordedExchange) GetDepth(symbol models.Symbol) (*models.Depth, error) {
+	depth := ex.depthes[symbol.String()]
+	if depth == nil {
+		return nil, fmt.Errorf("Not found depth for %s", symbol)
+	}
+	return depth, nil
+}
+
+func (ex *recordedExchange) GetDepthOnUpdate() chan *models.Depth {
+	return make(chan *models.Depth)
+}
+
+func (ex *recordedExchange) SendOrder(order *models.Order) error {
+	return nil
+}
+
+func (ex *recordedExchange) ConfirmOrder(order *models.Order) (float64, error) {
+	return 0, nil
+}
+
+func (ex *recordedExchange) QueryOrder(order *models.Order) error {
+	return nil
+}
+
+func (ex *recordedExchange) CancelOrder(order *models.Order) error {
+	return nil
+}
+
+func (ex *recordedExchange) GetOrderEvents() chan *models.OrderEvent {
+	return make(chan *models.OrderEvent)
+}
+
+// crossVenues trade the recorded depthes on "a" holding BTC and on "b"
+// holding XRP.
+func crossVenues(t *testing.T) []testVenue {
+	a, err := newRecordedExchange("a", "testdata/cross_depthes.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := newRecordedExchange("b", "testdata/cross_depthes.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []testVenue{
+		{exchange: a, balances: []*models.Balance{{Asset: "BTC", Free: 1.0, Total: 1.0}}},
+		{exchange: b, balances: []*models.Balance{{Asset: "XRP", Free: 10000, Total: 10000}}},
+	}
+}
+
+func TestBestOfCrossSequence(t *testing.T) {
+	trader := newTestTrader(testOptions{venues: crossVenues(t)})
+
+	depth := trader.caches["a"].Get(models.Symbol{Text: "XRPBTC"})
+	seq := trader.bestOfCrossSequence(depth)
+	if seq == nil || seq.Next == nil {
+		t.Fatal("test failed")
+	}
+
+	if seq.Exchange != "a" || seq.Side != models.SideBuy ||
+		seq.Next.Exchange != "b" || seq.Next.Side != models.SideSell {
+		t.Fatal("test failed")
+	}
+
+	if seq.Next.Target != 3000 {
+		t.Fatalf("test failed %f", seq.Next.Target)
+	}
+
+	depth = trader.caches["a"].Get(models.Symbol{Text: "ETHBTC"})
+	if trader.bestOfCrossSequence(depth) != nil {
+		t.Fatal("test failed")
+	}
+}
+
+func TestDoCrossSequence(t *testing.T) {
+	trader := newTestTrader(testOptions{venues: crossVenues(t)})
+	stubA := trader.exchanges["a"].(infrastructure.ExchangeStub)
+	stubB := trader.exchanges["b"].(infrastructure.ExchangeStub)
+
+	depth := trader.caches["b"].Get(models.Symbol{Text: "XRPBTC"})
+	seq := trader.bestOfCrossSequence(depth)
+	if seq == nil {
+		t.Fatal("test failed")
+	}
+
+	<-trader.doSequence(seq)
+
+	xrpA, _ := stubA.GetBalance("XRP")
+	btcA, _ := stubA.GetBalance("BTC")
+	xrpB, _ := stubB.GetBalance("XRP")
+	btcB, _ := stubB.GetBalance("BTC")
+
+	if xrpA == nil || math.Abs(xrpA.Free-2997) > 1e-9 || math.Abs(btcA.Free-0.7) > 1e-9 {
+		t.Fatal("test failed")
+	}
+
+	if xrpB.Free != 7000 || btcB == nil || math.Abs(btcB.Free-0.309*0.999) > 1e-9 {
+		t.Fatal("test failed")
+	}
+}
+
+func TestBestOfCrossSequenceFromCache(t *testing.T) {
+	trader := newTestTrader(testOptions{venues: crossVenues(t)})
+
+	depth := trader.caches["a"].Get(models.Symbol{Text: "XRPBTC"})
+	crossed := *depth
+	crossed.BidPrice = crossed.AskPrice * 1.1
+	crossed.Time = time.Now()
+	if !trader.caches["a"].Set(&crossed) {
+		t.Fatal("test failed")
+	}
+
+	// The depth refused by the cache is not traded on.
+	if trader.bestOfCrossSequence(&crossed) != nil || trader.bestOfCrossSequence(depth) != nil {
+		t.Fatal("test failed")
+	}
+}
@@ -0,0 +1,187 @@
+package usecase
+
+import (
+	models "github.com/OopsMouse/arbitgo/models"
+	"github.com/OopsMouse/arbitgo/util"
+)
+
+type cycleLeg struct {
+	Symbol models.Symbol
+	Side   models.OrderSide
+	From   string
+	To     string
+}
+
+type cycle []cycleLeg
+
+// cycleIndex holds every candidate cycle of the exchange's symbols, keyed by
+// the asset it starts from and by each symbol it trades, so that a depth
+// update only re-scores the cycles touching the updated symbol. It is built
+// once for the given starting assets and is read-only afterwards.
+type cycleIndex struct {
+	legs      map[string][]cycleLeg
+	symbols   map[string]struct{}
+	maxLength int
+	bySymbol  map[string]map[string][]cycle
+}
+
+func newCycleIndex(symbols []models.Symbol, froms []string, maxLength int) *cycleIndex {
+	legs := map[string][]cycleLeg{}
+	symbolSet := map[string]struct{}{}
+	for _, s := range symbols {
+		symbolSet[s.String()] = struct{}{}
+		legs[s.QuoteAsset] = append(legs[s.QuoteAsset], cycleLeg{
+			Symbol: s,
+			Side:   models.SideBuy,
+			From:   s.QuoteAsset,
+			To:     s.BaseAsset,
+		})
+		legs[s.BaseAsset] = append(legs[s.BaseAsset], cycleLeg{
+			Symbol: s,
+			Side:   models.SideSell,
+			From:   s.BaseAsset,
+			To:     s.QuoteAsset,
+		})
+	}
+	idx := &cycleIndex{
+		legs:      legs,
+		symbols:   symbolSet,
+		maxLength: maxLength,
+		bySymbol:  map[string]map[string][]cycle{},
+	}
+	for _, from := range froms {
+		bySymbol := map[string][]cycle{}
+		for _, c := range idx.enumerate(from) {
+			for _, leg := range c {
+				key := leg.Symbol.String()
+				bySymbol[key] = append(bySymbol[key], c)
+			}
+		}
+		idx.bySymbol[from] = bySymbol
+	}
+	return idx
+}
+
+// Get returns the cycles starting from the given asset which trade the given
+// symbol.
+func (idx *cycleIndex) Get(from string, symbol models.Symbol) []cycle {
+	return idx.bySymbol[from][symbol.String()]
+}
+
+// Include tells whether the cycles of the symbol starting from the asset are
+// indexed. Other assets are searched on the currency graph.
+func (idx *cycleIndex) Include(from string, symbol models.Symbol) bool {
+	if _, ok := idx.bySymbol[from]; !ok {
+		return false
+	}
+	_, ok := idx.symbols[symbol.String()]
+	return ok
+}
+
+func (idx *cycleIndex) enumerate(from string) []cycle {
+	cycles := []cycle{}
+	visited := map[string]bool{from: true}
+
+	var walk func(asset string, path cycle)
+	walk = func(asset string, path cycle) {
+		if len(path) >= idx.maxLength {
+			return
+		}
+		for _, leg := range idx.legs[asset] {
+			if len(path) > 0 && path[len(path)-1].Symbol.Equal(leg.Symbol) {
+				continue
+			}
+			if leg.To == from {
+				if len(path) > 0 {
+					c := make(cycle, len(path)+1)
+					copy(c, path)
+					c[len(path)] = leg
+					cycles = append(cycles, c)
+				}
+				continue
+			}
+			if visited[leg.To] {
+				continue
+			}
+			visited[leg.To] = true
+			walk(leg.To, append(path, leg))
+			visited[leg.To] = false
+		}
+	}
+	walk(from, cycle{})
+
+	return cycles
+}
+
+// rate returns the product of the leg rates after fee using the cached depthes,
+// or false when a depth of the cycle is not known yet.
+func (c cycle) rate(cache *util.DepthCache, fee float64) (float64, bool) {
+	rate := 1.0
+	for _, leg := range c {
+		depth := cache.Get(leg.Symbol)
+		if depth == nil {
+			return 0, false
+		}
+		if leg.Side == models.SideBuy {
+			if depth.AskPrice <= 0 {
+				return 0, false
+			}
+			rate *= (1 - fee) / depth.AskPrice
+		} else {
+			rate *= depth.BidPrice * (1 - fee)
+		}
+	}
+	return rate, true
+}
+
+// midRate is the rate of the legs at mid price without fee.
+func (c cycle) midRate(cache *util.DepthCache) (float64, bool) {
+	rate := 1.0
+	for _, leg := range c {
+		depth := cache.Get(leg.Symbol)
+		if depth == nil || depth.BidPrice <= 0 || depth.AskPrice <= 0 {
+			return 0, false
+		}
+		mid := (depth.BidPrice + depth.AskPrice) / 2
+		if leg.Side == models.SideBuy {
+			rate /= mid
+		} else {
+			rate *= mid
+		}
+	}
+	return rate, true
+}
+
+// toSequence also serves open paths, whose To is the last leg's asset.
+func (c cycle) toSequence(cache *util.DepthCache) *models.Sequence {
+	to := c[len(c)-1].To
+	var head *models.Sequence
+	var last *models.Sequence
+	for _, leg := range c {
+		depth := cache.Get(leg.Symbol)
+		if depth == nil {
+			return nil
+		}
+		s := &models.Sequence{
+			Symbol: leg.Symbol,
+			Side:   leg.Side,
+			From:   leg.From,
+			To:     to,
+			Src:    depth,
+		}
+		if leg.Side == models.SideBuy {
+			s.Price = depth.AskPrice
+			s.Quantity = depth.AskQty
+		} else {
+			s.Price = depth.BidPrice
+			s.Quantity = depth.BidQty
+		}
+		if head == nil {
+			head = s
+		} else {
+			last.Next = s
+		}
+		last = s
+	}
+	return head
+}
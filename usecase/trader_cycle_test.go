@@ -0,0 +1,64 @@
+package usecase
+
+import (
+	"testing"
+	"time"
+
+	"github.com/OopsMouse/arbitgo/models"
+	"github.com/OopsMouse/arbitgo/util"
+)
+
+func TestCycleIndex(t *testing.T) {
+	depthes := createPricedDepthes([][]interface{}{
+		{"XRP", "BTC", 0.000099, 0.0001},
+		{"XRP", "BNB", 0.0102, 0.0103},
+		{"BNB", "BTC", 0.01, 0.0101},
+		{"ETH", "USDT", 400.0, 401.0},
+	})
+	symbols := []models.Symbol{}
+	for _, d := range depthes {
+		symbols = append(symbols, d.Symbol)
+	}
+	idx := newCycleIndex(symbols, []string{"BTC"}, MAX_SEQUENCE_SIZE)
+
+	if !idx.Include("BTC", symbols[0]) || idx.Include("BTC", models.Symbol{Text: "LTCBTC"}) {
+		t.Fatal("test failed")
+	}
+
+	if idx.Include("XRP", symbols[0]) {
+		t.Fatal("test failed")
+	}
+
+	cycles := idx.Get("BTC", symbols[0])
+	if len(cycles) != 2 {
+		t.Fatal("test failed")
+	}
+
+	if len(idx.Get("BTC", symbols[3])) != 0 {
+		t.Fatal("test failed")
+	}
+
+	cache := util.NewDepthCache()
+	for _, d := range depthes {
+		d.Time = time.Now()
+		cache.Set(d)
+	}
+
+	profitable := 0
+	for _, c := range cycles {
+		rate, ok := c.rate(cache, 0.001)
+		if !ok {
+			t.Fatal("test failed")
+		}
+		if rate > 1 {
+			profitable++
+			if description(c.toSequence(cache)) != "XRPBTC,XRPBNB,BNBBTC" {
+				t.Fatal("test failed")
+			}
+		}
+	}
+
+	if profitable != 1 {
+		t.Fatal("test failed")
+	}
+}
@@ -1,8 +1,10 @@
 package usecase
 
 import (
+	"context"
 	"encoding/json"
 	"net/url"
+	"sync"
 
 	"github.com/OopsMouse/arbitgo/models"
 	"github.com/OopsMouse/arbitgo/util"
@@ -10,27 +12,71 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-func (trader *Trader) depthSubscriber() chan *models.Depth {
-	var depthChan chan *models.Depth
-	if trader.serverHost == nil || *trader.serverHost == "" {
-		depthChan = trader.Exchange.GetDepthOnUpdate()
-	} else {
-		depthChan = depthServerChannel(trader.serverHost)
-	}
-
+// depthSubscriber forwards the depthes of every venue the cache took until
+// the context is done.
+func (trader *Trader) depthSubscriber(ctx context.Context) chan *models.Depth {
 	depch := make(chan *models.Depth)
+	wg := new(sync.WaitGroup)
+	trader.configureCaches()
 
-	go func() {
-		for {
-			depth := <-depthChan
-			trader.cache.Set(depth)
-			depch <- depth
+	for _, name := range trader.exchangeNames() {
+		var depthChan chan *models.Depth
+		if name != trader.Exchange.Name() ||
+			trader.serverHost == nil || *trader.serverHost == "" {
+			depthChan = trader.exchanges[name].GetDepthOnUpdate()
+		} else {
+			depthChan = DepthServerChannel(trader.serverHost)
 		}
+
+		wg.Add(1)
+		go func(name string, depthChan chan *models.Depth) {
+			defer wg.Done()
+			cache := trader.caches[name]
+			for depth := range depthChan {
+				depth.Exchange = name
+				if !cache.Set(depth) {
+					continue
+				}
+				select {
+				case depch <- depth:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(name, depthChan)
+	}
+
+	go func() {
+		wg.Wait()
+		close(depch)
 	}()
 
 	return depch
 }
 
+// configureCaches applies the staleness thresholds and the clock to the depth
+// cache of every venue.
+func (trader *Trader) configureCaches() {
+	for _, cache := range trader.caches {
+		if trader.Clock != nil {
+			cache.SetClock(trader.Clock)
+		}
+		cache.SetMaxAge(trader.QuoteMaxAge)
+		for symbol, maxAge := range trader.SymbolMaxAge {
+			cache.SetSymbolMaxAge(symbol, maxAge)
+		}
+	}
+}
+
+// QuoteStats returns the counters of the depth cache of every venue.
+func (trader *Trader) QuoteStats() map[string]util.DepthCacheStats {
+	stats := map[string]util.DepthCacheStats{}
+	for name, cache := range trader.caches {
+		stats[name] = cache.Stats()
+	}
+	return stats
+}
+
 func (trader *Trader) getDepthes(asset string, renewAsset string) []*models.Depth {
 	quotes := trader.Exchange.GetQuotes()
 	all := trader.cache.GetAll()
@@ -45,7 +91,7 @@ func (trader *Trader) getDepthes(asset string, renewAsset string) []*models.Dept
 	return ret
 }
 
-func depthServerChannel(host *string) chan *models.Depth {
+func DepthServerChannel(host *string) chan *models.Depth {
 	dch := make(chan *models.Depth)
 	u := url.URL{Scheme: "ws", Host: *host, Path: "/ws"}
 	log.Printf("connecting to %s", u.String())
@@ -0,0 +1,31 @@
+package usecase
+
+import (
+	models "github.com/OopsMouse/arbitgo/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// onExchangeError reacts to an error the exchange gave on an order by its
+// kind and tells whether the leg counts as failed.
+func (trader *Trader) onExchangeError(action string, order *models.Order, err error) bool {
+	switch models.KindOf(err) {
+	case models.ErrRateLimited:
+		trader.pauseOn(err)
+		return false
+	case models.ErrUnknownOrder:
+		// Never reached the exchange, or is already done on it.
+		log.Info("Failed to ", action, " order ", order.ID, " : ", err)
+		return false
+	case models.ErrInsufficientFunds:
+		log.Warn("Failed to ", action, " order ", order.ID, " : ", err)
+		trader.LoadBalances()
+	case models.ErrAuth:
+		log.Error("Failed to ", action, " order ", order.ID, " : ", err)
+		trader.Kill("authentication failed")
+	case models.ErrNetwork:
+		log.Warn("Failed to ", action, " order ", order.ID, " : ", err)
+	default:
+		log.Error("Failed to ", action, " order ", order.ID, " : ", err)
+	}
+	return true
+}
@@ -0,0 +1,67 @@
+package usecase
+
+import (
+	"testing"
+
+	"github.com/OopsMouse/arbitgo/models"
+)
+
+func TestExchangeErrorAuthKills(t *testing.T) {
+	trader := newTestTrader(testOptions{parallel: true, balances: []*models.Balance{
+		{Asset: "BTC", Free: 0.1, Total: 0.1},
+	}})
+	seq := parallelSequence(trader)
+	if seq == nil {
+		t.Fatal("test failed")
+	}
+	trader.Exchange.(*testExchange).sendErr = models.NewExchangeError("test", models.ErrAuth, "invalid signature")
+
+	if _, status, _ := trader.doLeg(seq, true); status != ALLNG {
+		t.Fatal("test failed")
+	}
+	if killed, _ := trader.Killed(); !killed {
+		t.Fatal("test failed")
+	}
+}
+
+func TestExchangeErrorUnknownOrderNotCounted(t *testing.T) {
+	trader := newTestTrader(testOptions{parallel: true, balances: []*models.Balance{
+		{Asset: "BTC", Free: 0.1, Total: 0.1},
+	}})
+	seq := parallelSequence(trader)
+	if seq == nil {
+		t.Fatal("test failed")
+	}
+	trader.Risk.MaxConsecutiveFailures = 1
+	trader.Exchange.(*testExchange).sendErr = models.NewExchangeError("test", models.ErrUnknownOrder, "Order does not exist.")
+
+	if _, status, _ := trader.doLeg(seq, true); status != ALLNG {
+		t.Fatal("test failed")
+	}
+	if killed, _ := trader.Killed(); killed {
+		t.Fatal("test failed")
+	}
+}
+
+func TestSendOrderReconcilesOnTimeout(t *testing.T) {
+	trader := newTestTrader(testOptions{parallel: true, balances: []*models.Balance{
+		{Asset: "BTC", Free: 0.1, Total: 0.1},
+	}})
+	seq := parallelSequence(trader)
+	if seq == nil {
+		t.Fatal("test failed")
+	}
+	exchange := trader.Exchange.(*testExchange)
+	exchange.sendErr = models.NewExchangeError("test", models.ErrNetwork, "timeout")
+
+	order := models.NewOrder("order", seq.Exchange, seq.Symbol, models.TypeLimit, models.SideBuy, 0.0001, 100)
+	if failed, _ := trader.sendOrder(order); !failed {
+		t.Fatal("test failed")
+	}
+
+	exchange.accepted = map[string]bool{}
+	order = models.NewOrder("order2", seq.Exchange, seq.Symbol, models.TypeLimit, models.SideBuy, 0.0001, 100)
+	if failed, _ := trader.sendOrder(order); failed || order.Status != models.StatusNew {
+		t.Fatal("test failed")
+	}
+}
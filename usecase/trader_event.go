@@ -0,0 +1,69 @@
+package usecase
+
+import (
+	models "github.com/OopsMouse/arbitgo/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// watchOrder registers the order before it is sent so that none of its
+// events are missed, starting the venue's event listener on first use.
+func (trader *Trader) watchOrder(order *models.Order) chan *models.OrderEvent {
+	trader.listenOrderEvents(trader.exchangeOf(order.Exchange))
+
+	events := make(chan *models.OrderEvent, 16)
+	copied := *order
+	trader.waiterLock.Lock()
+	trader.waiters[order.ID] = events
+	trader.orders[order.ID] = &copied
+	trader.waiterLock.Unlock()
+	return events
+}
+
+func (trader *Trader) unwatchOrder(order *models.Order) {
+	trader.waiterLock.Lock()
+	delete(trader.waiters, order.ID)
+	delete(trader.orders, order.ID)
+	trader.waiterLock.Unlock()
+}
+
+// openOrders returns a copy, as sent, of every order not confirmed yet.
+func (trader *Trader) openOrders() []*models.Order {
+	defer trader.waiterLock.Unlock()
+	trader.waiterLock.Lock()
+	orders := []*models.Order{}
+	for _, order := range trader.orders {
+		copied := *order
+		orders = append(orders, &copied)
+	}
+	return orders
+}
+
+func (trader *Trader) listenOrderEvents(ex Exchange) {
+	trader.waiterLock.Lock()
+	if trader.listening[ex.Name()] {
+		trader.waiterLock.Unlock()
+		return
+	}
+	trader.listening[ex.Name()] = true
+	trader.waiterLock.Unlock()
+
+	evch := ex.GetOrderEvents()
+	go func() {
+		for event := range evch {
+			trader.waiterLock.Lock()
+			events := trader.waiters[event.OrderID]
+			trader.waiterLock.Unlock()
+
+			if events == nil {
+				log.Debug("Order event of unknown order : ", event.OrderID)
+				continue
+			}
+
+			select {
+			case events <- event:
+			default:
+				log.Warn("Drop order event of ", event.OrderID)
+			}
+		}
+	}()
+}
@@ -0,0 +1,194 @@
+package usecase
+
+import (
+	"math"
+
+	models "github.com/OopsMouse/arbitgo/models"
+)
+
+// graphEdge is one side of a symbol seen as a conversion between two assets.
+// Weight is -log of the rate after fee, so a cycle whose weights sum below
+// zero returns more than it started with.
+type graphEdge struct {
+	From     string
+	To       string
+	Side     models.OrderSide
+	Price    float64
+	Quantity float64
+	Weight   float64
+	Depth    *models.Depth
+}
+
+type currencyGraph struct {
+	assets []string
+	index  map[string]int
+	edges  []*graphEdge
+}
+
+func newCurrencyGraph(depthes []*models.Depth, fee float64) *currencyGraph {
+	graph := &currencyGraph{
+		assets: []string{},
+		index:  map[string]int{},
+		edges:  []*graphEdge{},
+	}
+	for _, depth := range depthes {
+		graph.addDepth(depth, fee)
+	}
+	return graph
+}
+
+func (g *currencyGraph) node(asset string) int {
+	if i, ok := g.index[asset]; ok {
+		return i
+	}
+	g.index[asset] = len(g.assets)
+	g.assets = append(g.assets, asset)
+	return g.index[asset]
+}
+
+func (g *currencyGraph) addDepth(depth *models.Depth, fee float64) {
+	g.node(depth.BaseAsset)
+	g.node(depth.QuoteAsset)
+
+	if depth.AskPrice > 0 {
+		g.edges = append(g.edges, &graphEdge{
+			From:     depth.QuoteAsset,
+			To:       depth.BaseAsset,
+			Side:     models.SideBuy,
+			Price:    depth.AskPrice,
+			Quantity: depth.AskQty,
+			Weight:   -math.Log((1 - fee) / depth.AskPrice),
+			Depth:    depth,
+		})
+	}
+
+	if depth.BidPrice > 0 {
+		g.edges = append(g.edges, &graphEdge{
+			From:     depth.BaseAsset,
+			To:       depth.QuoteAsset,
+			Side:     models.SideSell,
+			Price:    depth.BidPrice,
+			Quantity: depth.BidQty,
+			Weight:   -math.Log(depth.BidPrice * (1 - fee)),
+			Depth:    depth,
+		})
+	}
+}
+
+// walksPerHop is the width of the beam searchCycles keeps for every asset and
+// hop count.
+const walksPerHop = 8
+
+// walk is a simple path from the source asset.
+type walk struct {
+	weight float64
+	edges  []*graphEdge
+}
+
+// extend returns the walk followed by the edge, or nil when that revisits an
+// asset or a symbol. Only the source may be visited again, closing the walk.
+func (w *walk) extend(e *graphEdge, src string) *walk {
+	for _, x := range w.edges {
+		if x.Depth.Symbol.Equal(e.Depth.Symbol) || (x.From == e.To && e.To != src) {
+			return nil
+		}
+	}
+	edges := make([]*graphEdge, len(w.edges)+1)
+	copy(edges, w.edges)
+	edges[len(w.edges)] = e
+	return &walk{weight: w.weight + e.Weight, edges: edges}
+}
+
+// keepCheapest inserts the walk into the list ordered by weight, keeping at
+// most walksPerHop of them.
+func keepCheapest(walks []*walk, w *walk) []*walk {
+	i := len(walks)
+	for i > 0 && walks[i-1].weight > w.weight {
+		i--
+	}
+	if i >= walksPerHop {
+		return walks
+	}
+	walks = append(walks, nil)
+	copy(walks[i+1:], walks[i:])
+	walks[i] = w
+	if len(walks) > walksPerHop {
+		walks = walks[:walksPerHop]
+	}
+	return walks
+}
+
+// searchCycles returns the profitable cycles from the given asset back to it
+// up to maxLength legs. It is a beam search over simple walks rather than
+// Bellman-Ford: extending a walk one leg at a time, it keeps only the
+// walksPerHop cheapest walks reaching each asset in each hop count. A
+// profitable cycle whose prefix is not among them is missed, which bounds the
+// time spent on every depth update.
+func (g *currencyGraph) searchCycles(from string, maxLength int) [][]*graphEdge {
+	cycles := [][]*graphEdge{}
+
+	src, ok := g.index[from]
+	if !ok {
+		return cycles
+	}
+
+	n := len(g.assets)
+	walks := make([][]*walk, n)
+	walks[src] = []*walk{{}}
+
+	for k := 1; k <= maxLength; k++ {
+		next := make([][]*walk, n)
+		for _, e := range g.edges {
+			u := g.index[e.From]
+			if u == src && k > 1 {
+				continue
+			}
+			v := g.index[e.To]
+			for _, w := range walks[u] {
+				if extended := w.extend(e, from); extended != nil {
+					next[v] = keepCheapest(next[v], extended)
+				}
+			}
+		}
+		walks = next
+
+		if k < 2 {
+			continue
+		}
+		for _, w := range walks[src] {
+			if w.weight < 0 {
+				cycles = append(cycles, w.edges)
+			}
+		}
+	}
+
+	return cycles
+}
+
+func cycleToSequence(cycle []*graphEdge) *models.Sequence {
+	if len(cycle) == 0 {
+		return nil
+	}
+
+	to := cycle[0].From
+	var head *models.Sequence
+	var last *models.Sequence
+	for _, e := range cycle {
+		s := &models.Sequence{
+			Symbol:   e.Depth.Symbol,
+			Side:     e.Side,
+			From:     e.From,
+			To:       to,
+			Price:    e.Price,
+			Quantity: e.Quantity,
+			Src:      e.Depth,
+		}
+		if head == nil {
+			head = s
+		} else {
+			last.Next = s
+		}
+		last = s
+	}
+	return head
+}
@@ -0,0 +1,138 @@
+package usecase
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/OopsMouse/arbitgo/models"
+)
+
+func TestSearchCycles(t *testing.T) {
+	depthes := createPricedDepthes([][]interface{}{
+		{"XRP", "BTC", 0.000099, 0.0001},
+		{"XRP", "BNB", 0.0102, 0.0103},
+		{"BNB", "BTC", 0.01, 0.0101},
+	})
+	graph := newCurrencyGraph(depthes, 0.001)
+	cycles := graph.searchCycles("BTC", MAX_SEQUENCE_SIZE)
+
+	if len(cycles) != 1 {
+		t.Fatal("test failed")
+	}
+
+	seq := cycleToSequence(cycles[0])
+	if description(seq) != "XRPBTC,XRPBNB,BNBBTC" {
+		t.Fatal("test failed")
+	}
+
+	if seq.Side != models.SideBuy || seq.Next.Side != models.SideSell || seq.Next.Next.Side != models.SideSell {
+		t.Fatal("test failed")
+	}
+
+	if seq.From != "BTC" || seq.Next.From != "XRP" || seq.Next.Next.From != "BNB" || seq.Next.Next.To != "BTC" {
+		t.Fatal("test failed")
+	}
+}
+
+func TestSearchCyclesBehindNonSimpleWalk(t *testing.T) {
+	// The crossed XRPBNB book makes BTC,XRP,BNB,XRP the cheapest walk to XRP
+	// in three legs, which must not hide BTC,ETH,BNB,XRP.
+	depthes := createPricedDepthes([][]interface{}{
+		{"XRP", "BTC", 0.0001, 0.0001001},
+		{"ETH", "BTC", 0.05, 0.0501},
+		{"BNB", "ETH", 0.2, 0.2},
+		{"XRP", "BNB", 0.0103, 0.0095},
+	})
+	graph := newCurrencyGraph(depthes, 0.001)
+	cycles := graph.searchCycles("BTC", MAX_SEQUENCE_SIZE)
+
+	found := false
+	for _, cycle := range cycles {
+		if description(cycleToSequence(cycle)) == "ETHBTC,BNBETH,XRPBNB,XRPBTC" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("test failed")
+	}
+}
+
+func TestSearchCyclesBeyondBeam(t *testing.T) {
+	// BTC,XRP,ADA,BNB,ETH,BTC is profitable, but n walks BTC,ETH,Di,BNB reach
+	// BNB cheaper in three legs. They can not go on to ETH again, and once
+	// they fill the beam the profitable cycle is lost.
+	search := func(n int) bool {
+		symbols := [][]interface{}{
+			{"XRP", "BTC", 0.99, 1.0},
+			{"ADA", "XRP", 0.99, 1.0},
+			{"BNB", "ADA", 0.99, 1.0},
+			{"BNB", "ETH", 1.2, 1.21},
+			{"ETH", "BTC", 0.99, 1.0},
+		}
+		for i := 0; i < n; i++ {
+			d := fmt.Sprintf("D%d", i)
+			symbols = append(symbols,
+				[]interface{}{d, "ETH", 0.49, 0.5},
+				[]interface{}{"BNB", d, 0.49, 0.5})
+		}
+		graph := newCurrencyGraph(createPricedDepthes(symbols), 0.001)
+		for _, cycle := range graph.searchCycles("BTC", 5) {
+			if description(cycleToSequence(cycle)) == "XRPBTC,ADAXRP,BNBADA,BNBETH,ETHBTC" {
+				return true
+			}
+		}
+		return false
+	}
+
+	if !search(walksPerHop-1) || search(walksPerHop) {
+		t.Fatal("test failed")
+	}
+}
+
+func TestSearchCyclesNoProfit(t *testing.T) {
+	depthes := createPricedDepthes([][]interface{}{
+		{"XRP", "BTC", 0.000099, 0.0001},
+		{"XRP", "BNB", 0.0099, 0.01},
+		{"BNB", "BTC", 0.01, 0.0101},
+	})
+	graph := newCurrencyGraph(depthes, 0.001)
+	cycles := graph.searchCycles("BTC", MAX_SEQUENCE_SIZE)
+
+	if len(cycles) != 0 {
+		t.Fatal("test failed")
+	}
+}
+
+func TestSearchCyclesUnknownAsset(t *testing.T) {
+	depthes := createPricedDepthes([][]interface{}{
+		{"XRP", "BTC", 0.000099, 0.0001},
+	})
+	graph := newCurrencyGraph(depthes, 0.001)
+	cycles := graph.searchCycles("ETH", MAX_SEQUENCE_SIZE)
+
+	if len(cycles) != 0 {
+		t.Fatal("test failed")
+	}
+}
+
+func createPricedDepthes(symbols [][]interface{}) []*models.Depth {
+	depthes := []*models.Depth{}
+	for _, symbol := range symbols {
+		base := symbol[0].(string)
+		quote := symbol[1].(string)
+		depthes = append(depthes, &models.Depth{
+			BaseAsset:  base,
+			QuoteAsset: quote,
+			Symbol: models.Symbol{
+				Text:       base + quote,
+				BaseAsset:  base,
+				QuoteAsset: quote,
+			},
+			BidPrice: symbol[2].(float64),
+			AskPrice: symbol[3].(float64),
+			BidQty:   1000000,
+			AskQty:   1000000,
+		})
+	}
+	return depthes
+}
@@ -0,0 +1,105 @@
+package usecase
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	models "github.com/OopsMouse/arbitgo/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// restoreRecoveryDelay is how long the recovery of what a previous run left
+// waits for the depth feed to fill the cache.
+const restoreRecoveryDelay = 10 * time.Second
+
+func (trader *Trader) journalSequence(seq *models.Sequence) {
+	if trader.Journal == nil {
+		return
+	}
+	if err := trader.Journal.RecordSequence(seq); err != nil {
+		log.Error("Failed to journal sequence ", seq.ID, " : ", err)
+	}
+}
+
+func (trader *Trader) journalOrder(order *models.Order) {
+	if trader.Journal == nil {
+		return
+	}
+	if err := trader.Journal.RecordOrder(order); err != nil {
+		log.Error("Failed to journal order ", order.ID, " : ", err)
+	}
+}
+
+func (trader *Trader) journalBalances(sequenceID string) {
+	if trader.Journal == nil {
+		return
+	}
+	if err := trader.Journal.RecordBalances(sequenceID, trader.ledger.Balances()); err != nil {
+		log.Error("Failed to journal balances : ", err)
+	}
+}
+
+// restoreOrders settles the orders a previous run left open in the journal.
+// Each is looked up on its exchange, canceled if still open, booked as it
+// ended and journaled.
+func (trader *Trader) restoreOrders() {
+	if trader.Journal == nil {
+		return
+	}
+	orders, err := trader.Journal.OpenOrders()
+	if err != nil {
+		log.Error("Failed to read open orders of the journal : ", err)
+		return
+	}
+
+	for _, order := range orders {
+		log.Info("Restore open order : ", order.ID)
+		exchange := trader.exchanges[order.Exchange]
+		if exchange == nil {
+			log.Warn("Open order of unknown exchange : ", order.Exchange)
+			continue
+		}
+
+		err := exchange.QueryOrder(order)
+		if models.KindOf(err) == models.ErrUnknownOrder {
+			// Never reached the exchange.
+			if err := order.Transit(models.StatusExpired, time.Now()); err != nil {
+				log.Error("Failed to expire order ", order.ID, " : ", err)
+				continue
+			}
+		} else if err != nil {
+			log.Error("Failed to query order ", order.ID, " : ", err)
+			continue
+		} else if !order.IsDone() {
+			if err := exchange.CancelOrder(order); err != nil {
+				log.Error("Failed to cancel ", order.ID, " : ", err)
+			}
+			// It may have executed more before the cancel took.
+			if err := exchange.QueryOrder(order); err != nil {
+				log.Error("Failed to query order ", order.ID, " : ", err)
+				continue
+			}
+		}
+		if order.IsDone() {
+			spentAsset, spent, asset, received := fillOf(order, exchange.GetFee())
+			trader.ledger.Book(exchange.Name(), spentAsset, spent, asset, received)
+		}
+		trader.journalOrder(order)
+	}
+}
+
+// recoverRestored unwinds what a previous run left outside the home assets
+// once the depth feed has had time to come in. A run may have stopped between
+// the legs of a sequence with no order open, so it always runs.
+func (trader *Trader) recoverRestored(ctx context.Context) {
+	atomic.AddInt64(&trader.inflight, 1)
+	go func() {
+		defer atomic.AddInt64(&trader.inflight, -1)
+		select {
+		case <-time.After(restoreRecoveryDelay):
+			trader.Recover()
+		case <-ctx.Done():
+		}
+	}()
+}
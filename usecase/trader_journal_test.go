@@ -0,0 +1,162 @@
+package usecase
+
+import (
+	"math"
+	"testing"
+
+	"github.com/OopsMouse/arbitgo/infrastructure"
+	"github.com/OopsMouse/arbitgo/models"
+)
+
+type memJournal struct {
+	sequences []string
+	orders    map[string]models.Order
+	balances  []string
+}
+
+func newMemJournal() *memJournal {
+	return &memJournal{orders: map[string]models.Order{}}
+}
+
+func (j *memJournal) RecordSequence(seq *models.Sequence) error {
+	j.sequences = append(j.sequences, seq.ID)
+	return nil
+}
+
+func (j *memJournal) RecordOrder(order *models.Order) error {
+	j.orders[order.ID] = *order
+	return nil
+}
+
+func (j *memJournal) RecordBalances(sequenceID string, balances []*models.Balance) error {
+	j.balances = append(j.balances, sequenceID)
+	return nil
+}
+
+func (j *memJournal) OpenOrders() ([]*models.Order, error) {
+	orders := []*models.Order{}
+	for _, order := range j.orders {
+		if !order.IsDone() {
+			copied := order
+			orders = append(orders, &copied)
+		}
+	}
+	return orders, nil
+}
+
+func TestJournalSequence(t *testing.T) {
+	trader := newTestTrader(testOptions{parallel: true, balances: []*models.Balance{
+		{Asset: "BTC", Free: 0.1, Total: 0.1},
+		{Asset: "XRP", Free: 10000, Total: 10000},
+		{Asset: "BNB", Free: 100, Total: 100},
+	}})
+	seq := parallelSequence(trader)
+	if seq == nil {
+		t.Fatal("test failed")
+	}
+	journal := newMemJournal()
+	trader.Journal = journal
+
+	parallel, ok := trader.planSequence(seq)
+	if !ok {
+		t.Fatal("test failed")
+	}
+	trader.runSequence(seq, parallel)
+
+	if len(journal.orders) != 3 || len(journal.balances) != 1 || journal.balances[0] != seq.ID {
+		t.Fatal("test failed")
+	}
+	if orders, _ := journal.OpenOrders(); len(orders) != 0 {
+		t.Fatal("test failed")
+	}
+}
+
+func TestJournalRestoreOrders(t *testing.T) {
+	trader := newTestTrader(testOptions{parallel: true, balances: []*models.Balance{
+		{Asset: "BTC", Free: 0.1, Total: 0.1},
+	}})
+	seq := parallelSequence(trader)
+	if seq == nil {
+		t.Fatal("test failed")
+	}
+	exchange := trader.Exchange.(*testExchange)
+	exchange.accepted = map[string]bool{"live": true}
+	journal := newMemJournal()
+	trader.Journal = journal
+
+	for _, id := range []string{"live", "lost"} {
+		order := models.NewOrder(id, exchange.Name(), seq.Symbol, models.TypeLimit, seq.Side, seq.Price, 1)
+		journal.RecordOrder(order)
+	}
+
+	trader.restoreOrders()
+	if len(exchange.canceled) != 1 || exchange.canceled[0] != "live" {
+		t.Fatal("test failed")
+	}
+	if journal.orders["live"].Status != models.StatusNew || journal.orders["lost"].Status != models.StatusExpired {
+		t.Fatal("test failed")
+	}
+}
+
+func TestJournalBeforeSend(t *testing.T) {
+	trader := newTestTrader(testOptions{parallel: true, balances: []*models.Balance{
+		{Asset: "BTC", Free: 0.1, Total: 0.1},
+	}})
+	seq := parallelSequence(trader)
+	if seq == nil {
+		t.Fatal("test failed")
+	}
+	journal := newMemJournal()
+	trader.Journal = journal
+
+	journaled := false
+	trader.Exchange.(*testExchange).sending = func(order *models.Order) {
+		recorded, ok := journal.orders[order.ID]
+		journaled = ok && recorded.Status == models.StatusPendingNew
+	}
+	order, _, _ := trader.doLeg(seq, false)
+
+	if !journaled || journal.orders[order.ID].Status != order.Status {
+		t.Fatal("test failed")
+	}
+}
+
+func TestJournalRestoreBooksFills(t *testing.T) {
+	depthes := createPricedDepthes([][]interface{}{
+		{"XRP", "BTC", 0.000099, 0.0001},
+	})
+	depthes[0].AskQty = 40
+	depthes[0].Symbol.StepSize = 1
+	trader := newTestTrader(testOptions{depthes: depthes, stub: true, balances: []*models.Balance{
+		{Asset: "BTC", Free: 1.0, Total: 1.0},
+	}})
+	stub := trader.Exchange.(infrastructure.ExchangeStub)
+	journal := newMemJournal()
+	trader.Journal = journal
+
+	// A previous run journaled the order, which filled in part while it was
+	// down.
+	order := models.NewOrder("left", "test", depthes[0].Symbol, models.TypeLimit, models.SideBuy, 0.0001, 100)
+	order.TimeInForce = models.GTC
+	journal.RecordOrder(order)
+	if err := stub.SendOrder(order); err != nil {
+		t.Fatal(err)
+	}
+	if executed, _ := stub.ConfirmOrder(order); executed != 40 {
+		t.Fatal("test failed")
+	}
+
+	trader.restoreOrders()
+
+	restored := journal.orders["left"]
+	if restored.Status != models.StatusCanceled || restored.ExecutedQty != 40 || len(stub.ExecutingOrders) != 0 {
+		t.Fatalf("test failed %s %f", restored.Status, restored.ExecutedQty)
+	}
+	for _, asset := range []string{"BTC", "XRP"} {
+		b, _ := stub.GetBalance(asset)
+		l := trader.ledger.Balance("test", asset)
+		if b == nil || l == nil || math.Abs(b.Total-l.Total) > 1e-9 {
+			t.Fatalf("test failed %s", asset)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package usecase
+
+import (
+	"sync"
+	"sync/atomic"
+
+	models "github.com/OopsMouse/arbitgo/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// executeParallel sends every leg at once from the inventory already held in
+// each leg's asset and returns the orders of the legs and their results.
+func (trader *Trader) executeParallel(seq *models.Sequence) ([]*models.Order, []ConfirmStatus) {
+	log.Info("Start parallel trade")
+	defer func() {
+		log.Info("End parallel trade")
+	}()
+	trader.report.executed(seq)
+	trader.PrintSequence(seq)
+
+	legs := []*models.Sequence{}
+	for s := seq; s != nil; s = s.Next {
+		legs = append(legs, s)
+	}
+
+	orders := make([]*models.Order, len(legs))
+	statuses := make([]ConfirmStatus, len(legs))
+	wg := &sync.WaitGroup{}
+	for i, s := range legs {
+		wg.Add(1)
+		go func(i int, s *models.Sequence) {
+			defer wg.Done()
+			orders[i], statuses[i], _ = trader.doLeg(s, false)
+		}(i, s)
+	}
+	wg.Wait()
+	trader.settle(seq.ID)
+
+	trader.report.parallel(orders, statuses, trader.exchangeOf(seq.Exchange).GetFee())
+	return orders, statuses
+}
+
+// restoreInventory converts the surplus of each intermediate asset back to
+// the head asset and buys back what is missing, so the next parallel run
+// finds the same inventory. Like a rebalance, it does not run during another
+// conversion and each conversion runs within the risk limits of a sequence.
+func (trader *Trader) restoreInventory(seq *models.Sequence, orders []*models.Order, statuses []ConfirmStatus) {
+	failed := false
+	for _, status := range statuses {
+		if status != ALLOK {
+			failed = true
+		}
+	}
+	if !failed || trader.paused() || trader.killed() {
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&trader.converting, 0, 1) {
+		log.Warn("Skip restoring the inventory of ", seq.ID, " during another conversion")
+		return
+	}
+	defer trader.doneConverting()
+
+	deltas := map[string]float64{}
+	for i, s := 0, seq; s != nil; i, s = i+1, s.Next {
+		spentAsset, spent, receivedAsset, received := fillOf(orders[i], trader.exchangeOf(s.Exchange).GetFee())
+		deltas[spentAsset] -= spent
+		deltas[receivedAsset] += received
+	}
+
+	head := seq.From
+	fee := trader.Exchange.GetFee()
+	for asset, delta := range deltas {
+		if asset == head || delta == 0 {
+			continue
+		}
+
+		var conversion *models.Sequence
+		if delta > 0 {
+			conversion = trader.conversionSequence(asset, []string{head}, delta)
+		} else {
+			value, ok := trader.valueOf(asset, -delta, head)
+			if !ok {
+				continue
+			}
+			conversion = trader.conversionSequence(head, []string{asset}, value*(1+2*fee))
+		}
+		if conversion == nil {
+			continue
+		}
+		if trader.paused() || !trader.beginSequence(conversion) {
+			return
+		}
+
+		log.Infof("Restore %f %s", delta, asset)
+		trader.journalSequence(conversion)
+		<-trader.doSequence(conversion)
+		trader.endSequence()
+	}
+}
@@ -0,0 +1,105 @@
+package usecase
+
+import (
+	"testing"
+	"time"
+
+	"github.com/OopsMouse/arbitgo/models"
+)
+
+func TestParallelSequence(t *testing.T) {
+	trader := newTestTrader(testOptions{parallel: true, balances: []*models.Balance{
+		{Asset: "BTC", Free: 0.1, Total: 0.1},
+		{Asset: "XRP", Free: 10000, Total: 10000},
+		{Asset: "BNB", Free: 100, Total: 100},
+	}})
+	seq := parallelSequence(trader)
+	if seq == nil {
+		t.Fatal("test failed")
+	}
+
+	parallel, ok := trader.planSequence(seq)
+	if !parallel || !ok {
+		t.Fatal("test failed")
+	}
+
+	trader.runSequence(seq, parallel)
+
+	if trader.report.Completed != 1 || trader.report.PnL["BTC"] <= 0 {
+		t.Fatal("test failed")
+	}
+
+	if trader.report.Symbols["XRPBNB"].Filled != 1 {
+		t.Fatal("test failed")
+	}
+}
+
+func TestSequentialWithoutInventory(t *testing.T) {
+	trader := newTestTrader(testOptions{parallel: true, balances: []*models.Balance{
+		{Asset: "BTC", Free: 0.1, Total: 0.1},
+	}})
+	seq := parallelSequence(trader)
+	if seq == nil {
+		t.Fatal("test failed")
+	}
+
+	parallel, ok := trader.planSequence(seq)
+	if parallel || !ok {
+		t.Fatal("test failed")
+	}
+}
+
+func TestRestoreInventoryGuarded(t *testing.T) {
+	balances := []*models.Balance{
+		{Asset: "BTC", Free: 0.1, Total: 0.1},
+		{Asset: "XRP", Free: 10000, Total: 10000},
+		{Asset: "BNB", Free: 100, Total: 100},
+	}
+	restored := func(setup func(trader *Trader)) (*Trader, bool) {
+		trader := newTestTrader(testOptions{parallel: true, balances: balances})
+		seq := parallelSequence(trader)
+		if seq == nil {
+			t.Fatal("test failed")
+		}
+		exchange := trader.Exchange.(*testExchange)
+		exchange.accepted = map[string]bool{}
+
+		// Only the head leg filled, the asset it bought is restored.
+		orders := []*models.Order{}
+		statuses := []ConfirmStatus{}
+		for s := seq; s != nil; s = s.Next {
+			order := models.NewOrder("leg", s.Exchange, s.Symbol, models.TypeLimit, s.Side, s.Price, 100)
+			status := ALLNG
+			if s == seq {
+				order.ExecutedQty = order.Quantity
+				status = ALLOK
+			}
+			orders = append(orders, order)
+			statuses = append(statuses, status)
+		}
+
+		setup(trader)
+		trader.restoreInventory(seq, orders, statuses)
+		return trader, len(exchange.accepted) > 0
+	}
+
+	if _, ok := restored(func(trader *Trader) {}); !ok {
+		t.Fatal("test failed")
+	}
+	if _, ok := restored(func(trader *Trader) { trader.risk.killed = true }); ok {
+		t.Fatal("test failed")
+	}
+	if _, ok := restored(func(trader *Trader) { trader.pausedUntil = time.Now().Add(time.Minute).UnixNano() }); ok {
+		t.Fatal("test failed")
+	}
+	if trader, ok := restored(func(trader *Trader) { trader.converting = 1 }); ok || trader.converting != 1 {
+		t.Fatal("test failed")
+	}
+	trader, ok := restored(func(trader *Trader) {
+		trader.Risk.MaxConcurrent = 1
+		trader.risk.running = 1
+	})
+	if ok || trader.risk.running != 1 || trader.converting != 0 {
+		t.Fatal("test failed")
+	}
+}
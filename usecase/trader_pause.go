@@ -0,0 +1,30 @@
+package usecase
+
+import (
+	"sync/atomic"
+	"time"
+
+	models "github.com/OopsMouse/arbitgo/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// pauseOn pauses the trader until the exchange accepts requests again when
+// the error tells that it refuses them, and tells whether it did.
+func (trader *Trader) pauseOn(err error) bool {
+	e, ok := models.AsRateLimitError(err)
+	if !ok {
+		return false
+	}
+	log.Warn("Pause trading : ", e)
+	until := e.Until.UnixNano()
+	for {
+		current := atomic.LoadInt64(&trader.pausedUntil)
+		if current >= until || atomic.CompareAndSwapInt64(&trader.pausedUntil, current, until) {
+			return true
+		}
+	}
+}
+
+func (trader *Trader) paused() bool {
+	return time.Now().UnixNano() < atomic.LoadInt64(&trader.pausedUntil)
+}
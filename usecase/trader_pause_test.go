@@ -0,0 +1,35 @@
+package usecase
+
+import (
+	"testing"
+	"time"
+
+	"github.com/OopsMouse/arbitgo/models"
+)
+
+func TestPauseOnRateLimit(t *testing.T) {
+	trader := newTestTrader(testOptions{parallel: true, balances: []*models.Balance{
+		{Asset: "BTC", Free: 0.1, Total: 0.1},
+	}})
+	seq := parallelSequence(trader)
+	if seq == nil {
+		t.Fatal("test failed")
+	}
+	trader.Exchange.(*testExchange).sendErr = &models.RateLimitError{
+		Exchange: "test",
+		Banned:   true,
+		Until:    time.Now().Add(time.Minute),
+	}
+
+	if _, ok := trader.planSequence(seq); !ok {
+		t.Fatal("test failed")
+	}
+	_, status, _ := trader.doLeg(seq, true)
+	if status != ALLNG || !trader.paused() {
+		t.Fatal("test failed")
+	}
+
+	if trader.ledger.Reserved(legID(seq)) != 0 {
+		t.Fatal("test failed")
+	}
+}
@@ -0,0 +1,133 @@
+package usecase
+
+import (
+	"context"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// RebalanceConfig controls how often the home assets are brought back to
+// their allocations. Drift is the fraction of the total value an asset may be
+// away from its target before it is converted. A zero Interval disables it.
+type RebalanceConfig struct {
+	Interval time.Duration
+	Drift    float64
+}
+
+var DefaultRebalanceConfig = RebalanceConfig{
+	Interval: 0,
+	Drift:    0.05,
+}
+
+func (trader *Trader) runRebalancer(ctx context.Context) {
+	if trader.Rebalancer.Interval <= 0 || len(trader.HomeAssets) == 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(trader.Rebalancer.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				trader.track(trader.Rebalance)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Rebalance converts the home assets above their allocation into the ones
+// below it through the cheapest path. Values are taken at mid price in the
+// home asset with the largest allocation. Each conversion runs within the
+// risk limits of a sequence.
+func (trader *Trader) Rebalance() {
+	if len(trader.HomeAssets) == 0 || trader.paused() || trader.killed() {
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&trader.converting, 0, 1) {
+		return
+	}
+	defer trader.doneConverting()
+
+	log.Info("START - rebalance")
+	defer func() {
+		log.Info("END - rebalance")
+	}()
+
+	trader.LoadBalances()
+
+	assets := trader.homeAssets()
+	in := assets[0]
+	values := map[string]float64{}
+	prices := map[string]float64{}
+	total := 0.0
+	allocation := 0.0
+	for _, asset := range assets {
+		allocation += trader.HomeAssets[asset]
+		available := trader.GetAvailable("", asset)
+		if available <= 0 {
+			continue
+		}
+		value, ok := trader.valueOf(asset, available, in)
+		if !ok {
+			log.Warn("Can not value ", asset, " in ", in)
+			return
+		}
+		values[asset] = value
+		prices[asset] = value / available
+		total += value
+	}
+	if total <= 0 || allocation <= 0 {
+		return
+	}
+
+	drift := map[string]float64{}
+	for _, asset := range assets {
+		drift[asset] = values[asset] - total*trader.HomeAssets[asset]/allocation
+	}
+
+	overs := []string{}
+	for _, asset := range assets {
+		if drift[asset] > total*trader.Rebalancer.Drift && !trader.isRunningPosition(asset) {
+			overs = append(overs, asset)
+		}
+	}
+	sort.Slice(overs, func(i, j int) bool { return drift[overs[i]] > drift[overs[j]] })
+
+	for _, over := range overs {
+		under := ""
+		for _, asset := range assets {
+			if drift[asset] < 0 && (under == "" || drift[asset] < drift[under]) {
+				under = asset
+			}
+		}
+		if under == "" {
+			return
+		}
+
+		amount := drift[over]
+		if -drift[under] < amount {
+			amount = -drift[under]
+		}
+
+		seq := trader.conversionSequence(over, []string{under}, amount/prices[over])
+		if seq == nil {
+			continue
+		}
+		if !trader.beginSequence(seq) {
+			return
+		}
+		log.Infof("Rebalance %f %s to %s", amount/prices[over], over, under)
+		trader.journalSequence(seq)
+		trader.transfer(seq.ID, over)
+		<-trader.doSequence(seq)
+		trader.endSequence()
+
+		drift[over] -= amount
+		drift[under] += amount
+	}
+}
@@ -0,0 +1,99 @@
+package usecase
+
+import (
+	"testing"
+
+	"github.com/OopsMouse/arbitgo/models"
+)
+
+func TestRebalance(t *testing.T) {
+	depthes := createPricedDepthes([][]interface{}{
+		{"BNB", "BTC", 0.01, 0.0101},
+	})
+	depthes[0].Symbol.MaxQty = 1000
+	trader := newTestTrader(testOptions{depthes: depthes, balances: []*models.Balance{
+		{Asset: "BTC", Free: 1, Total: 1},
+	}})
+	trader.HomeAssets = map[string]float64{"BTC": 50, "BNB": 50}
+
+	trader.Rebalance()
+
+	report := trader.report.Symbols["BNBBTC"]
+	if report == nil || report.Orders != 1 || report.Filled != 1 {
+		t.Fatal("test failed")
+	}
+
+	if report.Quantity < 49 || report.Quantity > 50 {
+		t.Fatalf("test failed %f", report.Quantity)
+	}
+}
+
+func TestRebalanceWithinDrift(t *testing.T) {
+	depthes := createPricedDepthes([][]interface{}{
+		{"BNB", "BTC", 0.01, 0.0101},
+	})
+	trader := newTestTrader(testOptions{depthes: depthes, balances: []*models.Balance{
+		{Asset: "BTC", Free: 0.52, Total: 0.52},
+		{Asset: "BNB", Free: 48, Total: 48},
+	}})
+	trader.HomeAssets = map[string]float64{"BTC": 50, "BNB": 50}
+
+	trader.Rebalance()
+
+	if trader.report.Symbols["BNBBTC"] != nil {
+		t.Fatal("test failed")
+	}
+}
+
+func TestBigAssetsOfHomeAssets(t *testing.T) {
+	depthes := createPricedDepthes([][]interface{}{
+		{"BNB", "BTC", 0.01, 0.0101},
+		{"XRP", "BTC", 0.0001, 0.000101},
+	})
+	trader := newTestTrader(testOptions{depthes: depthes, balances: []*models.Balance{
+		{Asset: "BNB", Free: 10, Total: 10},
+		{Asset: "XRP", Free: 100, Total: 100},
+	}})
+
+	bigAssets := trader.BigAssets()
+	if len(bigAssets) != 1 || bigAssets[0] != "BNB" {
+		t.Fatal("test failed")
+	}
+}
+
+func TestRebalanceKilled(t *testing.T) {
+	depthes := createPricedDepthes([][]interface{}{
+		{"BNB", "BTC", 0.01, 0.0101},
+	})
+	depthes[0].Symbol.MaxQty = 1000
+	trader := newTestTrader(testOptions{depthes: depthes, balances: []*models.Balance{
+		{Asset: "BTC", Free: 1, Total: 1},
+	}})
+	trader.HomeAssets = map[string]float64{"BTC": 50, "BNB": 50}
+	trader.Kill("test")
+
+	trader.Rebalance()
+
+	if trader.report.Symbols["BNBBTC"] != nil {
+		t.Fatal("test failed")
+	}
+}
+
+func TestRebalanceCountsRunning(t *testing.T) {
+	depthes := createPricedDepthes([][]interface{}{
+		{"BNB", "BTC", 0.01, 0.0101},
+	})
+	depthes[0].Symbol.MaxQty = 1000
+	trader := newTestTrader(testOptions{depthes: depthes, balances: []*models.Balance{
+		{Asset: "BTC", Free: 1, Total: 1},
+	}})
+	trader.HomeAssets = map[string]float64{"BTC": 50, "BNB": 50}
+	trader.Risk.MaxConcurrent = 1
+	trader.risk.running = 1
+
+	trader.Rebalance()
+
+	if trader.report.Symbols["BNBBTC"] != nil || trader.risk.running != 1 {
+		t.Fatal("test failed")
+	}
+}
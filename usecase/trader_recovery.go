@@ -0,0 +1,205 @@
+package usecase
+
+import (
+	"math"
+	"sort"
+	"sync/atomic"
+
+	models "github.com/OopsMouse/arbitgo/models"
+	"github.com/OopsMouse/arbitgo/util"
+	"github.com/rs/xid"
+	log "github.com/sirupsen/logrus"
+)
+
+// RecoveryConfig controls how assets stranded by a broken sequence are
+// unwound. Aggressiveness is the fraction each limit price crosses beyond the
+// simulated fill price, and MaxLoss the largest fraction of the asset's value
+// at mid price the unwind may give up.
+type RecoveryConfig struct {
+	Aggressiveness float64
+	MaxLoss        float64
+}
+
+var DefaultRecoveryConfig = RecoveryConfig{
+	Aggressiveness: 0.001,
+	MaxLoss:        0.01,
+}
+
+// homeAssets returns the configured home assets, largest allocation first,
+// or the exchange's quote assets when none are configured.
+func (trader *Trader) homeAssets() []string {
+	if len(trader.HomeAssets) == 0 {
+		return trader.Exchange.GetQuotes()
+	}
+	assets := []string{}
+	for asset := range trader.HomeAssets {
+		assets = append(assets, asset)
+	}
+	sort.Slice(assets, func(i, j int) bool {
+		if trader.HomeAssets[assets[i]] != trader.HomeAssets[assets[j]] {
+			return trader.HomeAssets[assets[i]] > trader.HomeAssets[assets[j]]
+		}
+		return assets[i] < assets[j]
+	})
+	return assets
+}
+
+func (trader *Trader) isHomeAsset(asset string) bool {
+	return util.Include(trader.homeAssets(), asset)
+}
+
+// Recover unwinds every asset held on the primary venue outside the home
+// assets back into one of them. Only one conversion runs at a time, so a
+// call made during another one is queued and run once that ends.
+func (trader *Trader) Recover() {
+	atomic.StoreInt32(&trader.recoverQueued, 1)
+	for atomic.LoadInt32(&trader.recoverQueued) == 1 {
+		if !atomic.CompareAndSwapInt32(&trader.converting, 0, 1) {
+			return
+		}
+		if atomic.CompareAndSwapInt32(&trader.recoverQueued, 1, 0) {
+			trader.recover()
+		}
+		atomic.StoreInt32(&trader.converting, 0)
+	}
+}
+
+// doneConverting ends a conversion other than a recovery and runs the
+// recovery queued meanwhile.
+func (trader *Trader) doneConverting() {
+	atomic.StoreInt32(&trader.converting, 0)
+	if atomic.LoadInt32(&trader.recoverQueued) == 1 {
+		trader.Recover()
+	}
+}
+
+// recoverAfter unwinds the asset a broken sequence left. A broken recovery
+// is not run again on its own, or it would retry for as long as the market
+// does not fill it.
+func (trader *Trader) recoverAfter(seq *models.Sequence, asset string) {
+	if trader.recoveries.Include(seq.ID) {
+		log.Warn("Recovery ", seq.ID, " left ", asset)
+		return
+	}
+	trader.Recover()
+}
+
+func (trader *Trader) recover() {
+	log.Info("START - recovery")
+	defer func() {
+		log.Info("END - recovery")
+	}()
+
+	trader.LoadBalances()
+	stranded := map[string]float64{}
+	for _, balance := range trader.ledger.Balances() {
+		if balance.Exchange != trader.Exchange.Name() || trader.isHomeAsset(balance.Asset) {
+			continue
+		}
+		if available := trader.GetAvailable(balance.Exchange, balance.Asset); available > 0 {
+			stranded[balance.Asset] = available
+		}
+	}
+
+	for asset, quantity := range stranded {
+		if trader.isRunningPosition(asset) {
+			continue
+		}
+		seq := trader.conversionSequence(asset, trader.homeAssets(), quantity)
+		if seq == nil {
+			continue
+		}
+		log.Infof("Recover %f %s to %s", quantity, asset, seq.To)
+		trader.journalSequence(seq)
+		trader.recoveries.Append(seq.ID)
+		<-trader.doSequence(seq)
+		trader.recoveries.Remove(seq.ID)
+	}
+}
+
+// conversionSequence returns the conversion of quantity of the asset into one
+// of the given assets, directly or via a quote asset, which loses the least
+// against the mid price, or nil when every path loses more than the loss cap.
+func (trader *Trader) conversionSequence(asset string, tos []string, quantity float64) *models.Sequence {
+	aggressiveness := trader.Recovery.Aggressiveness
+	minLoss := trader.Recovery.MaxLoss
+	var best *models.Sequence
+
+	for _, path := range trader.conversionPaths(asset, tos) {
+		mid, ok := path.midRate(trader.cache)
+		if !ok {
+			continue
+		}
+		seq := path.toSequence(trader.cache)
+		if seq == nil {
+			continue
+		}
+		out, err := trader.sizeSequence(seq, quantity)
+		if err != nil {
+			log.Debugf("Skip conversion path %s -> %s : %s", asset, seq.To, err)
+			continue
+		}
+
+		for s := seq; s != nil; s = s.Next {
+			if s.Side == models.SideBuy {
+				s.Price = util.Ceil(s.Price*(1+aggressiveness), s.Symbol.TickSize)
+			} else {
+				s.Price = util.Floor(s.Price*(1-aggressiveness), s.Symbol.TickSize)
+			}
+		}
+
+		worst := out * math.Pow(1-aggressiveness, float64(len(path)))
+		loss := 1 - worst/(quantity*mid)
+		log.Debugf("Conversion path %s -> %s via %s loss : %f", asset, seq.To, seq.Symbol, loss)
+		if loss <= minLoss {
+			minLoss = loss
+			best = seq
+		}
+	}
+
+	if best == nil {
+		log.Warn("No conversion path of ", asset, " within the loss cap")
+		return nil
+	}
+
+	best.ID = xid.New().String()
+	for s := best.Next; s != nil; s = s.Next {
+		s.ID = best.ID
+	}
+	return best
+}
+
+func (trader *Trader) conversionPaths(asset string, tos []string) []cycle {
+	paths := []cycle{}
+	quotes := trader.Exchange.GetQuotes()
+	for _, leg := range trader.index.legs[asset] {
+		if util.Include(tos, leg.To) {
+			paths = append(paths, cycle{leg})
+			continue
+		}
+		if !util.Include(quotes, leg.To) {
+			continue
+		}
+		for _, next := range trader.index.legs[leg.To] {
+			if next.To != asset && util.Include(tos, next.To) {
+				paths = append(paths, cycle{leg, next})
+			}
+		}
+	}
+	return paths
+}
+
+// valueOf returns quantity of the asset valued in the given asset at mid
+// price, through the best direct or quote path.
+func (trader *Trader) valueOf(asset string, quantity float64, in string) (float64, bool) {
+	if asset == in {
+		return quantity, true
+	}
+	value := 0.0
+	for _, path := range trader.conversionPaths(asset, []string{in}) {
+		if rate, ok := path.midRate(trader.cache); ok && quantity*rate > value {
+			value = quantity * rate
+		}
+	}
+	return value, value > 0
+}
@@ -0,0 +1,146 @@
+package usecase
+
+import (
+	"testing"
+	"time"
+
+	"github.com/OopsMouse/arbitgo/infrastructure"
+	"github.com/OopsMouse/arbitgo/models"
+)
+
+func recoveryDepthes() []*models.Depth {
+	return createPricedDepthes([][]interface{}{
+		{"XRP", "BTC", 0.0001, 0.000102},
+		{"XRP", "BNB", 0.01, 0.0101},
+		{"BNB", "BTC", 0.01, 0.0101},
+	})
+}
+
+func TestRecoverySequence(t *testing.T) {
+	trader := newTestTrader(testOptions{depthes: recoveryDepthes(), balances: []*models.Balance{
+		{Asset: "XRP", Free: 100, Total: 100},
+	}})
+	trader.Recovery.MaxLoss = 0.02
+
+	seq := trader.conversionSequence("XRP", trader.homeAssets(), 100)
+	if seq == nil || seq.ID == "" {
+		t.Fatal("test failed")
+	}
+
+	if seq.Symbol.String() != "XRPBNB" || seq.Side != models.SideSell || seq.To != "BNB" || seq.Next != nil {
+		t.Fatal("test failed")
+	}
+
+	if seq.Price >= 0.01 {
+		t.Fatal("test failed")
+	}
+}
+
+func TestRecoverySequenceViaQuote(t *testing.T) {
+	trader := newTestTrader(testOptions{depthes: recoveryDepthes(), balances: []*models.Balance{
+		{Asset: "XRP", Free: 100, Total: 100},
+	}})
+	trader.HomeAssets = map[string]float64{"BTC": 1}
+	trader.Recovery.MaxLoss = 0.02
+
+	seq := trader.conversionSequence("XRP", trader.homeAssets(), 100)
+	if seq == nil || seq.To != "BTC" {
+		t.Fatal("test failed")
+	}
+}
+
+func TestRecoverySequenceLossCap(t *testing.T) {
+	trader := newTestTrader(testOptions{depthes: recoveryDepthes(), balances: []*models.Balance{
+		{Asset: "XRP", Free: 100, Total: 100},
+	}})
+	trader.Recovery.MaxLoss = 0.001
+
+	if trader.conversionSequence("XRP", trader.homeAssets(), 100) != nil {
+		t.Fatal("test failed")
+	}
+}
+
+func TestRecoverySequenceBuyLimit(t *testing.T) {
+	depthes := createPricedDepthes([][]interface{}{
+		{"XRP", "BTC", 0.0001, 0.000102},
+		{"BNB", "BTC", 0.01, 0.0101},
+	})
+	for _, d := range depthes {
+		d.Symbol.TickSize = 0.0001
+	}
+	trader := newTestTrader(testOptions{depthes: depthes, balances: []*models.Balance{
+		{Asset: "XRP", Free: 100, Total: 100},
+	}})
+	trader.HomeAssets = map[string]float64{"BNB": 1}
+	trader.Recovery.MaxLoss = 0.03
+
+	seq := trader.conversionSequence("XRP", trader.homeAssets(), 100)
+	if seq == nil || seq.Next == nil || seq.Next.Side != models.SideBuy {
+		t.Fatal("test failed")
+	}
+	if seq.Next.Price < 0.0101*(1+trader.Recovery.Aggressiveness) {
+		t.Fatalf("test failed %f", seq.Next.Price)
+	}
+}
+
+func TestRecoverQueued(t *testing.T) {
+	trader := newTestTrader(testOptions{depthes: recoveryDepthes(), balances: []*models.Balance{
+		{Asset: "XRP", Free: 100, Total: 100},
+	}})
+	trader.ConfirmTimeout = 10 * time.Millisecond
+	trader.converting = 1
+
+	trader.Recover()
+	if trader.recoverQueued != 1 {
+		t.Fatal("test failed")
+	}
+
+	trader.doneConverting()
+	if trader.recoverQueued != 0 || trader.converting != 0 {
+		t.Fatal("test failed")
+	}
+}
+
+func TestRecoverAfterPartialLastLeg(t *testing.T) {
+	depthes := createPricedDepthes([][]interface{}{
+		{"XRP", "BTC", 0.0000999, 0.0001},
+		{"XRP", "BNB", 0.01, 0.0101},
+	})
+	for _, d := range depthes {
+		d.Symbol.StepSize = 1
+		d.Symbol.MaxQty = 1000000
+	}
+	depthes[1].BidQty = 40
+	trader := newTestTrader(testOptions{depthes: depthes, stub: true, balances: []*models.Balance{
+		{Asset: "BTC", Free: 1.0, Total: 1.0},
+	}})
+	stub := trader.Exchange.(infrastructure.ExchangeStub)
+	trader.Recovery.MaxLoss = 0.03
+
+	seq := &models.Sequence{
+		ID:       "partial",
+		Exchange: "test",
+		Symbol:   depthes[0].Symbol,
+		Side:     models.SideBuy,
+		From:     "BTC",
+		To:       "XRP",
+		Price:    0.0001,
+		Target:   0.01,
+		Next: &models.Sequence{
+			ID:       "partial",
+			Exchange: "test",
+			Symbol:   depthes[1].Symbol,
+			Side:     models.SideSell,
+			From:     "XRP",
+			To:       "BNB",
+			Price:    0.01,
+		},
+	}
+	<-trader.doSequence(seq)
+
+	bnb, _ := stub.GetBalance("BNB")
+	xrp, _ := stub.GetBalance("XRP")
+	if bnb == nil || bnb.Total <= 0 || xrp == nil || xrp.Total >= 1 {
+		t.Fatal("test failed")
+	}
+}
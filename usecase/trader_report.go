@@ -0,0 +1,295 @@
+package usecase
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+
+	models "github.com/OopsMouse/arbitgo/models"
+	"github.com/OopsMouse/arbitgo/util"
+	log "github.com/sirupsen/logrus"
+)
+
+type SymbolReport struct {
+	Detected int     `json:"detected"`
+	Orders   int     `json:"orders"`
+	Filled   int     `json:"filled"`
+	Failed   int     `json:"failed"`
+	Quantity float64 `json:"quantity"`
+}
+
+// Report collects what the trader detected and executed and how it went.
+type Report struct {
+	Detected      int                             `json:"detected"`
+	Executed      int                             `json:"executed"`
+	Skipped       int                             `json:"skipped"`
+	Completed     int                             `json:"completed"`
+	Partial       int                             `json:"partial"`
+	Stranded      int                             `json:"stranded"`
+	Unfilled      int                             `json:"unfilled"`
+	PnL           map[string]float64              `json:"pnl"`
+	PredictedRate float64                         `json:"predicted_rate"`
+	RealizedRate  float64                         `json:"realized_rate"`
+	Symbols       map[string]*SymbolReport        `json:"symbols"`
+	Balances      []*models.Balance               `json:"balances"`
+	Quotes        map[string]util.DepthCacheStats `json:"quotes"`
+	predictedSum  float64
+	realizedSum   float64
+	sequences     map[string]*sequenceReport
+	lock          *sync.Mutex
+}
+
+type sequenceReport struct {
+	predicted float64
+	start     float64
+	started   bool
+}
+
+func NewReport() *Report {
+	return &Report{
+		PnL:       map[string]float64{},
+		Symbols:   map[string]*SymbolReport{},
+		Balances:  []*models.Balance{},
+		Quotes:    map[string]util.DepthCacheStats{},
+		sequences: map[string]*sequenceReport{},
+		lock:      new(sync.Mutex),
+	}
+}
+
+func (r *Report) symbol(symbol models.Symbol) *SymbolReport {
+	s := r.Symbols[symbol.String()]
+	if s == nil {
+		s = &SymbolReport{}
+		r.Symbols[symbol.String()] = s
+	}
+	return s
+}
+
+func (r *Report) detected(seq *models.Sequence) {
+	defer r.lock.Unlock()
+	r.lock.Lock()
+	r.Detected++
+	for s := seq; s != nil; s = s.Next {
+		r.symbol(s.Symbol).Detected++
+	}
+}
+
+func (r *Report) skipped(seq *models.Sequence) {
+	defer r.lock.Unlock()
+	r.lock.Lock()
+	r.Skipped++
+}
+
+func (r *Report) executed(seq *models.Sequence) {
+	defer r.lock.Unlock()
+	r.lock.Lock()
+	r.Executed++
+	r.predictedSum += seq.Score
+	r.sequences[seq.ID] = &sequenceReport{
+		predicted: seq.Score,
+	}
+}
+
+// order records the result of a leg and, when the leg ends its sequence,
+// whether the sequence completed, filled its last leg in part, stranded or
+// never filled. Legs are valued at their average price net of commission,
+// see fillOf. Like a stranded one, a partial sequence counts no PnL since
+// what it left is unwound by the recovery.
+func (r *Report) order(order models.Order, status ConfirmStatus, executed float64, fee float64) {
+	defer r.lock.Unlock()
+	r.lock.Lock()
+
+	seq := order.Sequence
+	r.symbolOrder(order, status, executed)
+
+	report := r.sequences[seq.ID]
+	if report == nil {
+		return
+	}
+
+	if status == ALLNG {
+		r.stop(seq.ID, report)
+		return
+	}
+
+	r.otherCommission(&order)
+	_, spent, _, end := fillOf(&order, fee)
+	if !report.started {
+		report.started = true
+		report.start = spent
+	}
+
+	if seq.Next != nil {
+		return
+	}
+	delete(r.sequences, seq.ID)
+
+	if status == PARTOK {
+		r.Partial++
+		return
+	}
+	r.Completed++
+	r.PnL[seq.To] += end - report.start
+	if report.start > 0 {
+		r.realizedSum += (end - report.start) / report.start
+	}
+}
+
+// ended closes the report of a sequence which stopped before its last leg
+// was reported, such as by a kill between legs.
+func (r *Report) ended(id string) {
+	defer r.lock.Unlock()
+	r.lock.Lock()
+	if report := r.sequences[id]; report != nil {
+		r.stop(id, report)
+	}
+}
+
+// stop counts the sequence as stranded when a leg filled and as unfilled
+// otherwise. The caller holds lock.
+func (r *Report) stop(id string, report *sequenceReport) {
+	if report.started {
+		r.Stranded++
+	} else {
+		r.Unfilled++
+	}
+	delete(r.sequences, id)
+}
+
+// otherCommission counts a commission charged in an asset the order did not
+// trade, such as BNB, as a loss of that asset.
+func (r *Report) otherCommission(order *models.Order) {
+	asset := order.CommissionAsset
+	if asset == "" || asset == order.Symbol.BaseAsset || asset == order.Symbol.QuoteAsset {
+		return
+	}
+	r.PnL[asset] -= order.Commission
+}
+
+func (r *Report) symbolOrder(order models.Order, status ConfirmStatus, executed float64) {
+	sym := r.symbol(order.Symbol)
+	sym.Orders++
+	if status == ALLNG {
+		sym.Failed++
+	} else {
+		sym.Filled++
+		sym.Quantity += executed
+	}
+}
+
+// parallel reports the legs of a sequence which were sent at once. It is
+// completed only when every leg is filled in full.
+func (r *Report) parallel(orders []*models.Order, statuses []ConfirmStatus, fee float64) {
+	defer r.lock.Unlock()
+	r.lock.Lock()
+
+	filled := 0
+	partial := false
+	for i, order := range orders {
+		r.symbolOrder(*order, statuses[i], order.ExecutedQty)
+		if statuses[i] != ALLNG {
+			filled++
+		}
+		if statuses[i] == PARTOK {
+			partial = true
+		}
+	}
+
+	head := orders[0]
+	last := orders[len(orders)-1]
+	report := r.sequences[head.Sequence.ID]
+	if report == nil {
+		return
+	}
+	delete(r.sequences, head.Sequence.ID)
+
+	if filled == 0 {
+		r.Unfilled++
+		return
+	}
+	if filled < len(orders) {
+		r.Stranded++
+		return
+	}
+	if partial {
+		r.Partial++
+		return
+	}
+
+	for _, order := range orders {
+		r.otherCommission(order)
+	}
+	_, start, _, _ := fillOf(head, fee)
+	_, _, _, end := fillOf(last, fee)
+
+	r.Completed++
+	r.PnL[last.Sequence.To] += end - start
+	if start > 0 {
+		r.realizedSum += (end - start) / start
+	}
+}
+
+func (r *Report) summarize(balances []*models.Balance, quotes map[string]util.DepthCacheStats) {
+	defer r.lock.Unlock()
+	r.lock.Lock()
+	if r.Executed > 0 {
+		r.PredictedRate = r.predictedSum / float64(r.Executed)
+	}
+	if r.Completed > 0 {
+		r.RealizedRate = r.realizedSum / float64(r.Completed)
+	}
+	r.Balances = balances
+	r.Quotes = quotes
+}
+
+func (r *Report) JSON() ([]byte, error) {
+	defer r.lock.Unlock()
+	r.lock.Lock()
+	return json.MarshalIndent(r, "", "  ")
+}
+
+func (r *Report) Print() {
+	defer r.lock.Unlock()
+	r.lock.Lock()
+
+	log.Info("------------------ Report ------------------")
+	log.Infof("Detected       : %d", r.Detected)
+	log.Infof("Executed       : %d", r.Executed)
+	log.Infof("Skipped        : %d", r.Skipped)
+	log.Infof("Completed      : %d", r.Completed)
+	log.Infof("Partial        : %d", r.Partial)
+	log.Infof("Stranded       : %d", r.Stranded)
+	log.Infof("Unfilled       : %d", r.Unfilled)
+	log.Infof("Predicted Rate : %f", r.PredictedRate)
+	log.Infof("Realized Rate  : %f", r.RealizedRate)
+	for asset, pnl := range r.PnL {
+		log.Infof("PnL %s : %f", asset, pnl)
+	}
+
+	symbols := []string{}
+	for s := range r.Symbols {
+		symbols = append(symbols, s)
+	}
+	sort.Strings(symbols)
+	for _, s := range symbols {
+		sym := r.Symbols[s]
+		log.Infof("%s : detected %d, orders %d, filled %d, failed %d, quantity %f",
+			s, sym.Detected, sym.Orders, sym.Filled, sym.Failed, sym.Quantity)
+	}
+
+	exchanges := []string{}
+	for name := range r.Quotes {
+		exchanges = append(exchanges, name)
+	}
+	sort.Strings(exchanges)
+	for _, name := range exchanges {
+		q := r.Quotes[name]
+		log.Infof("Quotes %s : updates %d, out of order %d, crossed %d, locked %d, stale rejects %d, suspect rejects %d, latency avg %s, max %s",
+			name, q.Updates, q.OutOfOrder, q.Crossed, q.Locked, q.StaleRejects, q.SuspectRejects, q.LatencyAvg, q.LatencyMax)
+	}
+
+	for _, b := range r.Balances {
+		log.Info(b.Asset, " : ", b.Total)
+	}
+	log.Info("--------------------------------------------")
+}
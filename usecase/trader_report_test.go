@@ -0,0 +1,124 @@
+package usecase
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/OopsMouse/arbitgo/infrastructure"
+	"github.com/OopsMouse/arbitgo/models"
+)
+
+func TestBacktest(t *testing.T) {
+	depthes := createPricedDepthes([][]interface{}{
+		{"BTC", "USDT", 10000.0, 10001.0},
+		{"XRP", "BTC", 0.000099, 0.0001},
+		{"XRP", "BNB", 0.0102, 0.0103},
+		{"BNB", "BTC", 0.01, 0.0101},
+	})
+	ex := &testExchange{
+		name:    "test",
+		fee:     0.001,
+		symbols: []models.Symbol{},
+		depthes: map[string]*models.Depth{},
+		feed:    depthes,
+	}
+	for _, d := range depthes {
+		d.Symbol.StepSize = 0.01
+		d.Symbol.MinQty = 0.01
+		d.Symbol.MaxQty = 100000000
+		ex.symbols = append(ex.symbols, d.Symbol)
+		ex.depthes[d.Symbol.String()] = d
+	}
+
+	stub := infrastructure.NewExchangeStub(ex, map[string]*models.Balance{
+		"BTC": {Asset: "BTC", Free: 1.0, Total: 1.0},
+	})
+	report := NewTrader(stub, nil).Backtest()
+
+	if report.Detected != 1 || report.Executed != 1 || report.Completed != 1 {
+		t.Fatalf("test failed %+v", report)
+	}
+
+	if report.PnL["BTC"] <= 0 || report.RealizedRate <= 0 || report.PredictedRate <= 0 {
+		t.Fatalf("test failed %+v", report)
+	}
+
+	if report.Symbols["XRPBNB"].Filled != 1 {
+		t.Fatal("test failed")
+	}
+
+	if _, err := report.JSON(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReportValuesFills(t *testing.T) {
+	symbol := models.Symbol{Text: "XRPBTC", BaseAsset: "XRP", QuoteAsset: "BTC"}
+	seq := &models.Sequence{ID: "seq", Symbol: symbol, Side: models.SideBuy, From: "BTC", To: "BTC"}
+	seq.Next = &models.Sequence{ID: "seq", Symbol: symbol, Side: models.SideSell, From: "XRP", To: "BTC"}
+
+	report := NewReport()
+	report.executed(seq)
+
+	// Filled below the limit with the commission charged in XRP.
+	buy := models.NewOrder("buy", "test", symbol, models.TypeLimit, models.SideBuy, 0.0001, 1000)
+	buy.Sequence = seq
+	buy.Fill(1000, 0.00009, 1, time.Now())
+	buy.CommissionAsset = "XRP"
+	report.order(*buy, ALLOK, 1000, 0.001)
+
+	// Filled above the limit with the commission charged in BNB.
+	sell := models.NewOrder("sell", "test", symbol, models.TypeLimit, models.SideSell, 0.0001, 999)
+	sell.Sequence = seq.Next
+	sell.Fill(999, 0.00011, 0.01, time.Now())
+	sell.CommissionAsset = "BNB"
+	report.order(*sell, ALLOK, 999, 0.001)
+
+	if report.Completed != 1 || math.Abs(report.PnL["BTC"]-(999*0.00011-1000*0.00009)) > 1e-12 || report.PnL["BNB"] != -0.01 {
+		t.Fatalf("test failed %+v", report.PnL)
+	}
+}
+
+func TestReportPartialLastLeg(t *testing.T) {
+	symbol := models.Symbol{Text: "XRPBTC", BaseAsset: "XRP", QuoteAsset: "BTC"}
+	seq := &models.Sequence{ID: "seq", Symbol: symbol, Side: models.SideBuy, From: "BTC", To: "BTC"}
+	seq.Next = &models.Sequence{ID: "seq", Symbol: symbol, Side: models.SideSell, From: "XRP", To: "BTC"}
+
+	report := NewReport()
+	report.executed(seq)
+
+	buy := models.NewOrder("buy", "test", symbol, models.TypeLimit, models.SideBuy, 0.0001, 1000)
+	buy.Sequence = seq
+	buy.Fill(1000, 0.0001, 1, time.Now())
+	report.order(*buy, ALLOK, 1000, 0.001)
+
+	sell := models.NewOrder("sell", "test", symbol, models.TypeLimit, models.SideSell, 0.00011, 999)
+	sell.Sequence = seq.Next
+	sell.Fill(500, 0.00011, 0, time.Now())
+	report.order(*sell, PARTOK, 500, 0.001)
+
+	if report.Partial != 1 || report.Completed != 0 || len(report.sequences) != 0 {
+		t.Fatal("test failed")
+	}
+}
+
+func TestReportKilledBetweenLegs(t *testing.T) {
+	trader := newTestTrader(testOptions{parallel: true, balances: []*models.Balance{
+		{Asset: "BTC", Free: 0.1, Total: 0.1},
+	}})
+	seq := parallelSequence(trader)
+	if seq == nil {
+		t.Fatal("test failed")
+	}
+	trader.Exchange.(*testExchange).sending = func(order *models.Order) {
+		trader.risk.killed = true
+	}
+	trader.report.executed(seq)
+
+	<-trader.doSequence(seq)
+
+	if trader.report.Stranded != 1 || len(trader.report.sequences) != 0 {
+		t.Fatal("test failed")
+	}
+}
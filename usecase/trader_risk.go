@@ -0,0 +1,261 @@
+package usecase
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	models "github.com/OopsMouse/arbitgo/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// RiskConfig bounds what the trader may do. Notionals are keyed by asset, the
+// quote asset of an order or the input asset of a sequence, and loss limits
+// by home asset. Zero or missing means unlimited.
+type RiskConfig struct {
+	MaxOrderNotional       map[string]float64
+	MaxSequenceNotional    map[string]float64
+	MaxConcurrent          int
+	MaxDailyLoss           map[string]float64
+	MaxConsecutiveFailures int
+}
+
+var DefaultRiskConfig = RiskConfig{
+	MaxOrderNotional:    map[string]float64{},
+	MaxSequenceNotional: map[string]float64{},
+	MaxDailyLoss:        map[string]float64{},
+}
+
+// riskState keeps what moved in and out of the home assets today. The flows
+// of a sequence are pending until it ends, so that a sequence in flight does
+// not count as a loss.
+type riskState struct {
+	running   int
+	failures  int
+	day       time.Time
+	realized  map[string]float64
+	pending   map[string]map[string]float64
+	transfers map[string]string
+	killed    bool
+	reason    string
+	lock      *sync.Mutex
+}
+
+func newRiskState() *riskState {
+	return &riskState{
+		realized:  map[string]float64{},
+		pending:   map[string]map[string]float64{},
+		transfers: map[string]string{},
+		lock:      new(sync.Mutex),
+	}
+}
+
+// Killed tells whether the kill switch is on and why.
+func (trader *Trader) Killed() (bool, string) {
+	defer trader.risk.lock.Unlock()
+	trader.risk.lock.Lock()
+	return trader.risk.killed, trader.risk.reason
+}
+
+func (trader *Trader) killed() bool {
+	killed, _ := trader.Killed()
+	return killed
+}
+
+// Kill stops the analyzer from trading and cancels every open order. The
+// recovery still unwinds what is left outside the home assets.
+func (trader *Trader) Kill(reason string) {
+	trader.risk.lock.Lock()
+	if trader.risk.killed {
+		trader.risk.lock.Unlock()
+		return
+	}
+	trader.risk.killed = true
+	trader.risk.reason = reason
+	trader.risk.lock.Unlock()
+
+	log.Error("Kill switch : ", reason)
+	trader.cancelOpenOrders()
+	atomic.AddInt64(&trader.inflight, 1)
+	go func() {
+		defer atomic.AddInt64(&trader.inflight, -1)
+		trader.Recover()
+	}()
+}
+
+func (trader *Trader) cancelOpenOrders() {
+	for _, order := range trader.openOrders() {
+		log.Info("Cancel open order : ", order.ID)
+		err := trader.exchangeOf(order.Exchange).CancelOrder(order)
+		if err != nil {
+			log.Error("Failed to cancel ", order.ID, " : ", err)
+		}
+	}
+}
+
+// Resume turns the kill switch off and starts counting failures afresh.
+func (trader *Trader) Resume() {
+	defer trader.risk.lock.Unlock()
+	trader.risk.lock.Lock()
+	if trader.risk.killed {
+		log.Info("Resume trading")
+	}
+	trader.risk.killed = false
+	trader.risk.reason = ""
+	trader.risk.failures = 0
+}
+
+// capSequence limits the quantity of the asset a sequence may start with.
+func (trader *Trader) capSequence(asset string, quantity float64) float64 {
+	if max := trader.Risk.MaxSequenceNotional[asset]; max > 0 && quantity > max {
+		return max
+	}
+	return quantity
+}
+
+// beginSequence tells whether the sequence may run and counts it as running
+// if so. Every sequence begun must be ended.
+func (trader *Trader) beginSequence(seq *models.Sequence) bool {
+	trader.checkDailyLoss()
+
+	defer trader.risk.lock.Unlock()
+	trader.risk.lock.Lock()
+
+	if trader.risk.killed {
+		return false
+	}
+	if trader.Risk.MaxConcurrent > 0 && trader.risk.running >= trader.Risk.MaxConcurrent {
+		log.Debug("Too many sequences running : ", trader.risk.running)
+		return false
+	}
+	if max := trader.Risk.MaxSequenceNotional[seq.From]; max > 0 && seq.Target > max*(1+1e-9) {
+		log.Warnf("Sequence notional exceeds the limit : %f > %f %s", seq.Target, max, seq.From)
+		return false
+	}
+	trader.risk.running++
+	return true
+}
+
+func (trader *Trader) endSequence() {
+	trader.risk.lock.Lock()
+	trader.risk.running--
+	trader.risk.lock.Unlock()
+
+	trader.checkDailyLoss()
+}
+
+// checkOrder tells whether the notional of the order is within the limit.
+func (trader *Trader) checkOrder(order *models.Order) bool {
+	quote := order.Symbol.QuoteAsset
+	max := trader.Risk.MaxOrderNotional[quote]
+	if max > 0 && order.Quantity*order.Price > max*(1+1e-9) {
+		log.Warnf("Order notional exceeds the limit : %f > %f %s", order.Quantity*order.Price, max, quote)
+		return false
+	}
+	return true
+}
+
+// legResult counts the consecutive legs which failed and kills the trader
+// when there are too many.
+func (trader *Trader) legResult(status ConfirmStatus) {
+	trader.risk.lock.Lock()
+	if status != ALLNG {
+		trader.risk.failures = 0
+		trader.risk.lock.Unlock()
+		return
+	}
+	trader.risk.failures++
+	failures := trader.risk.failures
+	trader.risk.lock.Unlock()
+
+	if max := trader.Risk.MaxConsecutiveFailures; max > 0 && failures >= max {
+		trader.Kill("too many consecutive failed legs")
+	}
+}
+
+// roll starts the flows of a new day when the UTC day changed. The caller
+// holds lock.
+func (r *riskState) roll(now time.Time) {
+	today := now.UTC().Truncate(24 * time.Hour)
+	if !r.day.Equal(today) {
+		r.day = today
+		r.realized = map[string]float64{}
+	}
+}
+
+// transfer values the flows of the sequence in the given asset, for a
+// conversion between home assets not to count as the loss of one and the
+// gain of another.
+func (trader *Trader) transfer(id string, in string) {
+	defer trader.risk.lock.Unlock()
+	trader.risk.lock.Lock()
+	trader.risk.transfers[id] = in
+}
+
+// realize books what a fill of the sequence spent of a home asset and
+// received of one at the prices it executed.
+func (trader *Trader) realize(seq *models.Sequence, spentAsset string, spent float64, receivedAsset string, received float64) {
+	trader.risk.lock.Lock()
+	in, transfer := trader.risk.transfers[seq.ID]
+	trader.risk.lock.Unlock()
+
+	flows := map[string]float64{}
+	for asset, quantity := range map[string]float64{spentAsset: -spent, receivedAsset: received} {
+		if quantity == 0 || !trader.isHomeAsset(asset) {
+			continue
+		}
+		if !transfer {
+			flows[asset] += quantity
+		} else if value, ok := trader.valueOf(asset, math.Abs(quantity), in); ok {
+			flows[in] += math.Copysign(value, quantity)
+		} else if quantity < 0 {
+			log.Warn("Can not value ", asset, " in ", in)
+			flows[asset] += quantity
+		}
+	}
+
+	defer trader.risk.lock.Unlock()
+	trader.risk.lock.Lock()
+	pending := trader.risk.pending[seq.ID]
+	if pending == nil {
+		pending = map[string]float64{}
+		trader.risk.pending[seq.ID] = pending
+	}
+	for asset, flow := range flows {
+		pending[asset] += flow
+	}
+}
+
+// settle adds the flows of the ended sequence to the ones realized today.
+func (trader *Trader) settle(id string) {
+	trader.risk.lock.Lock()
+	trader.risk.roll(time.Now())
+	for asset, flow := range trader.risk.pending[id] {
+		trader.risk.realized[asset] += flow
+	}
+	delete(trader.risk.pending, id)
+	delete(trader.risk.transfers, id)
+	trader.risk.lock.Unlock()
+
+	trader.checkDailyLoss()
+}
+
+// checkDailyLoss kills the trader when what the sequences ended today lost of
+// a home asset exceeds the loss limit. An asset left stranded counts as lost
+// until it is recovered. Days are UTC.
+func (trader *Trader) checkDailyLoss() {
+	trader.risk.lock.Lock()
+	trader.risk.roll(time.Now())
+	exceeded := ""
+	for asset, max := range trader.Risk.MaxDailyLoss {
+		if max > 0 && -trader.risk.realized[asset] > max {
+			exceeded = asset
+		}
+	}
+	trader.risk.lock.Unlock()
+
+	if exceeded != "" {
+		trader.Kill("daily loss limit of " + exceeded + " exceeded")
+	}
+}
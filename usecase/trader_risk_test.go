@@ -0,0 +1,151 @@
+package usecase
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/OopsMouse/arbitgo/models"
+)
+
+func TestRiskConsecutiveFailures(t *testing.T) {
+	trader := newTestTrader(testOptions{parallel: true, balances: []*models.Balance{
+		{Asset: "BTC", Free: 0.1, Total: 0.1},
+	}})
+	seq := parallelSequence(trader)
+	if seq == nil {
+		t.Fatal("test failed")
+	}
+	trader.Risk.MaxConsecutiveFailures = 2
+	trader.Exchange.(*testExchange).sendErr = errors.New("Unexpected error")
+
+	for i := 0; i < 2; i++ {
+		if killed, _ := trader.Killed(); killed {
+			t.Fatal("test failed")
+		}
+		if _, status, _ := trader.doLeg(seq, true); status != ALLNG {
+			t.Fatal("test failed")
+		}
+	}
+
+	if killed, _ := trader.Killed(); !killed {
+		t.Fatal("test failed")
+	}
+	if _, ok := trader.planSequence(seq); ok {
+		t.Fatal("test failed")
+	}
+
+	trader.Resume()
+	if _, ok := trader.planSequence(seq); !ok {
+		t.Fatal("test failed")
+	}
+}
+
+func TestRiskNotional(t *testing.T) {
+	trader := newTestTrader(testOptions{parallel: true, balances: []*models.Balance{
+		{Asset: "BTC", Free: 0.1, Total: 0.1},
+	}})
+	seq := parallelSequence(trader)
+	if seq == nil {
+		t.Fatal("test failed")
+	}
+
+	trader.Risk.MaxSequenceNotional = map[string]float64{"BTC": seq.Target / 2}
+	if _, ok := trader.planSequence(seq); ok {
+		t.Fatal("test failed")
+	}
+	if trader.capSequence("BTC", seq.Target) != seq.Target/2 {
+		t.Fatal("test failed")
+	}
+
+	trader.Risk.MaxSequenceNotional = map[string]float64{}
+	trader.Risk.MaxOrderNotional = map[string]float64{"BTC": seq.Target / 2}
+	if _, ok := trader.planSequence(seq); !ok {
+		t.Fatal("test failed")
+	}
+	if _, status, _ := trader.doLeg(seq, true); status != ALLNG {
+		t.Fatal("test failed")
+	}
+	if trader.ledger.Reserved(legID(seq)) != 0 {
+		t.Fatal("test failed")
+	}
+}
+
+func TestRiskMaxConcurrent(t *testing.T) {
+	trader := newTestTrader(testOptions{parallel: true, balances: []*models.Balance{
+		{Asset: "BTC", Free: 0.1, Total: 0.1},
+	}})
+	seq := parallelSequence(trader)
+	if seq == nil {
+		t.Fatal("test failed")
+	}
+	trader.Risk.MaxConcurrent = 1
+
+	if !trader.beginSequence(seq) || trader.beginSequence(seq) {
+		t.Fatal("test failed")
+	}
+	trader.endSequence()
+	if !trader.beginSequence(seq) {
+		t.Fatal("test failed")
+	}
+}
+
+func TestRiskDailyLoss(t *testing.T) {
+	trader := newTestTrader(testOptions{parallel: true, balances: []*models.Balance{
+		{Asset: "BTC", Free: 0.1, Total: 0.1},
+	}})
+	trader.Risk.MaxDailyLoss = map[string]float64{"BTC": 0.01}
+
+	done := &models.Sequence{ID: "done"}
+	trader.realize(done, "BTC", 0.1, "XRP", 1000)
+	trader.checkDailyLoss()
+	if killed, _ := trader.Killed(); killed {
+		t.Fatal("test failed")
+	}
+	trader.realize(done, "XRP", 1000, "BTC", 0.095)
+	trader.settle(done.ID)
+	if killed, _ := trader.Killed(); killed {
+		t.Fatal("test failed")
+	}
+
+	stranded := &models.Sequence{ID: "stranded"}
+	trader.realize(stranded, "BTC", 0.01, "XRP", 100)
+	trader.settle(stranded.ID)
+	if killed, _ := trader.Killed(); !killed {
+		t.Fatal("test failed")
+	}
+}
+
+func TestRiskDailyLossTransfer(t *testing.T) {
+	trader := newTestTrader(testOptions{parallel: true, balances: []*models.Balance{
+		{Asset: "BTC", Free: 0.1, Total: 0.1},
+	}})
+
+	rebalance := &models.Sequence{ID: "rebalance"}
+	trader.transfer(rebalance.ID, "BTC")
+	trader.realize(rebalance, "BTC", 0.1, "BNB", 9.9)
+	trader.settle(rebalance.ID)
+
+	if math.Abs(trader.risk.realized["BTC"]+0.000505) > 1e-9 || trader.risk.realized["BNB"] != 0 {
+		t.Fatal("test failed")
+	}
+}
+
+func TestKillCancelsOpenOrders(t *testing.T) {
+	trader := newTestTrader(testOptions{parallel: true, balances: []*models.Balance{
+		{Asset: "BTC", Free: 0.1, Total: 0.1},
+	}})
+	seq := parallelSequence(trader)
+	if seq == nil {
+		t.Fatal("test failed")
+	}
+
+	order := models.NewOrder("open", "test", seq.Symbol, models.TypeLimit, seq.Side, seq.Price, 1)
+	trader.watchOrder(order)
+	trader.Kill("test")
+
+	canceled := trader.Exchange.(*testExchange).canceled
+	if len(canceled) != 1 || canceled[0] != "open" {
+		t.Fatal("test failed")
+	}
+}
@@ -0,0 +1,53 @@
+package usecase
+
+import (
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const DefaultShutdownTimeout = 30 * time.Second
+
+const (
+	shutdownGrace        = 10 * time.Second
+	shutdownPollInterval = 100 * time.Millisecond
+)
+
+// track runs f counted as in flight, so that the shutdown waits for it.
+func (trader *Trader) track(f func()) {
+	atomic.AddInt64(&trader.inflight, 1)
+	defer atomic.AddInt64(&trader.inflight, -1)
+	f()
+}
+
+// waitInflight waits until nothing is in flight and tells whether it came
+// to that within the timeout.
+func (trader *Trader) waitInflight(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for atomic.LoadInt64(&trader.inflight) > 0 {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(shutdownPollInterval)
+	}
+	return true
+}
+
+// shutdown waits for the sequences in flight to finish. Those still running
+// after ShutdownTimeout are stopped by the kill switch, which cancels their
+// orders and unwinds what they left, then the final report is printed.
+func (trader *Trader) shutdown() {
+	log.Info("Waiting for ", atomic.LoadInt64(&trader.inflight), " sequences in flight")
+	if !trader.waitInflight(trader.ShutdownTimeout) {
+		trader.Kill("shutdown")
+		if !trader.waitInflight(shutdownGrace) {
+			log.Warn("Sequences still in flight : ", atomic.LoadInt64(&trader.inflight))
+		}
+	}
+	trader.cancelOpenOrders()
+
+	trader.LoadBalances()
+	trader.report.summarize(trader.ledger.Balances(), trader.QuoteStats())
+	trader.report.Print()
+}
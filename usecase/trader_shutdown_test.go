@@ -0,0 +1,57 @@
+package usecase
+
+import (
+	"testing"
+	"time"
+
+	"github.com/OopsMouse/arbitgo/models"
+)
+
+func TestShutdownWaitsForInflight(t *testing.T) {
+	trader := newTestTrader(testOptions{parallel: true, balances: []*models.Balance{
+		{Asset: "BTC", Free: 0.1, Total: 0.1},
+	}})
+
+	done := make(chan struct{})
+	go trader.track(func() {
+		time.Sleep(200 * time.Millisecond)
+		close(done)
+	})
+	time.Sleep(10 * time.Millisecond)
+
+	trader.shutdown()
+	select {
+	case <-done:
+	default:
+		t.Fatal("test failed")
+	}
+	if killed, _ := trader.Killed(); killed {
+		t.Fatal("test failed")
+	}
+}
+
+func TestShutdownKillsAfterTimeout(t *testing.T) {
+	trader := newTestTrader(testOptions{parallel: true, balances: []*models.Balance{
+		{Asset: "BTC", Free: 0.1, Total: 0.1},
+	}})
+	seq := parallelSequence(trader)
+	if seq == nil {
+		t.Fatal("test failed")
+	}
+	trader.ShutdownTimeout = 100 * time.Millisecond
+
+	order := models.NewOrder("open", "test", seq.Symbol, models.TypeLimit, seq.Side, seq.Price, 1)
+	trader.watchOrder(order)
+	go trader.track(func() {
+		for !trader.killed() {
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+	time.Sleep(10 * time.Millisecond)
+
+	trader.shutdown()
+	canceled := trader.Exchange.(*testExchange).canceled
+	if len(canceled) < 1 || canceled[0] != "open" {
+		t.Fatal("test failed")
+	}
+}
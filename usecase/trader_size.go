@@ -0,0 +1,162 @@
+package usecase
+
+import (
+	"errors"
+
+	models "github.com/OopsMouse/arbitgo/models"
+	"github.com/OopsMouse/arbitgo/util"
+)
+
+const SIZING_STEPS = 20
+
+var (
+	errSizeTooSmall = errors.New("quantity is below the symbol filters")
+	errSizeTooLarge = errors.New("quantity exceeds the symbol filters or the liquidity")
+)
+
+// sizeOfSequence returns the quantity of sequence.From, up to maxQuantity,
+// which every leg can absorb and which yields the largest absolute profit.
+func (trader *Trader) sizeOfSequence(sequence *models.Sequence, maxQuantity float64) float64 {
+	upper := trader.maxQuantityOfSequence(sequence, maxQuantity)
+	if upper <= 0 {
+		return 0
+	}
+
+	size := 0.0
+	maxProfit := 0.0
+	for i := 1; i <= SIZING_STEPS; i++ {
+		quantity := upper * float64(i) / SIZING_STEPS
+		result, err := trader.simulateSequence(sequence, quantity)
+		if err != nil {
+			continue
+		}
+		if profit := result - quantity; profit > maxProfit {
+			maxProfit = profit
+			size = quantity
+		}
+	}
+
+	if size > 0 {
+		trader.sizeSequence(sequence, size)
+	}
+
+	return size
+}
+
+func (trader *Trader) maxQuantityOfSequence(sequence *models.Sequence, maxQuantity float64) float64 {
+	_, err := trader.simulateSequence(sequence, maxQuantity)
+	if err == nil {
+		return maxQuantity
+	}
+	if err == errSizeTooSmall {
+		return 0
+	}
+
+	lower := 0.0
+	upper := maxQuantity
+	for i := 0; i < 40; i++ {
+		mid := (lower + upper) / 2
+		_, err := trader.simulateSequence(sequence, mid)
+		if err == errSizeTooLarge {
+			upper = mid
+		} else {
+			lower = mid
+		}
+	}
+
+	if _, err := trader.simulateSequence(sequence, lower); err != nil {
+		return 0
+	}
+	return lower
+}
+
+// simulateSequence walks the legs of the sequence starting with quantity of
+// sequence.From and returns the quantity of sequence.To it ends with. The
+// sequence is left untouched.
+func (trader *Trader) simulateSequence(sequence *models.Sequence, quantity float64) (float64, error) {
+	return trader.sizeLegs(copySequence(sequence), quantity)
+}
+
+// sizeSequence simulates the sequence like simulateSequence and sets the
+// Price and Target of each leg to the limit price and the input quantity of
+// its order.
+func (trader *Trader) sizeSequence(sequence *models.Sequence, quantity float64) (float64, error) {
+	return trader.sizeLegs(sequence, quantity)
+}
+
+func copySequence(sequence *models.Sequence) *models.Sequence {
+	var head *models.Sequence
+	var last *models.Sequence
+	for s := sequence; s != nil; s = s.Next {
+		c := *s
+		c.Next = nil
+		if head == nil {
+			head = &c
+		} else {
+			last.Next = &c
+		}
+		last = &c
+	}
+	return head
+}
+
+// sizeLegs places the orders newOrder would send for each leg: a buy spends
+// Target at the limit price, which is the worst price its quantity reaches.
+// The fee is taken from what each leg receives.
+func (trader *Trader) sizeLegs(sequence *models.Sequence, quantity float64) (float64, error) {
+	fee := trader.Exchange.GetFee()
+	current := quantity
+
+	for s := sequence; s != nil; s = s.Next {
+		s.Target = current
+
+		book := trader.cache.GetOrderBook(s.Symbol)
+
+		var orderQty float64
+		var price float64
+		if s.Side == models.SideBuy {
+			if book != nil {
+				filled, vwap, worst := book.Buy(current)
+				if filled*vwap < current*(1-1e-9) {
+					return 0, errSizeTooLarge
+				}
+				price = worst
+			} else {
+				price = s.Price
+				if current/price > s.Quantity {
+					return 0, errSizeTooLarge
+				}
+			}
+			orderQty = util.Floor(current/price, s.Symbol.StepSize)
+			current = orderQty * (1 - fee)
+		} else {
+			orderQty = util.Floor(current, s.Symbol.StepSize)
+			if book != nil {
+				if orderQty > book.BidQuantity() {
+					return 0, errSizeTooLarge
+				}
+				current, _, price = book.Sell(orderQty)
+			} else {
+				price = s.Price
+				if orderQty > s.Quantity {
+					return 0, errSizeTooLarge
+				}
+				current = orderQty * price
+			}
+			current *= 1 - fee
+		}
+
+		if price > 0 {
+			s.Price = price
+		}
+
+		if s.Symbol.MaxQty > 0 && orderQty > s.Symbol.MaxQty {
+			return 0, errSizeTooLarge
+		}
+		if orderQty <= 0 || orderQty < s.Symbol.MinQty || orderQty*s.Price < s.Symbol.MinNotional {
+			return 0, errSizeTooSmall
+		}
+	}
+
+	return current, nil
+}
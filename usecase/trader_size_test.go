@@ -0,0 +1,125 @@
+package usecase
+
+import (
+	"math"
+	"testing"
+
+	"github.com/OopsMouse/arbitgo/models"
+	"github.com/OopsMouse/arbitgo/util"
+)
+
+func TestSizeOfSequenceTopOfBook(t *testing.T) {
+	depthes := createPricedDepthes([][]interface{}{
+		{"XRP", "BTC", 0.000099, 0.0001},
+		{"XRP", "BNB", 0.0102, 0.0103},
+		{"BNB", "BTC", 0.01, 0.0101},
+	})
+	depthes[0].AskQty = 5000
+	for _, d := range depthes {
+		d.Symbol.StepSize = 0.01
+		d.Symbol.MinQty = 0.01
+	}
+	trader := newTestTrader(testOptions{depthes: depthes, balances: []*models.Balance{
+		{Asset: "BTC", Free: 1.0, Total: 1.0},
+	}})
+
+	seq := trader.bestOfIndexedCycle("BTC", depthes[0].Symbol, 1.0)
+	if seq == nil {
+		t.Fatal("test failed")
+	}
+
+	if seq.Target > 0.51 || seq.Target < 0.45 {
+		t.Fatalf("test failed %f", seq.Target)
+	}
+}
+
+func TestSizeOfSequenceOrderBook(t *testing.T) {
+	depthes := createPricedDepthes([][]interface{}{
+		{"XRP", "BTC", 0.000099, 0.0001},
+		{"XRP", "BNB", 0.0102, 0.0103},
+		{"BNB", "BTC", 0.01, 0.0101},
+	})
+	for _, d := range depthes {
+		d.Symbol.StepSize = 0.01
+		d.OrderBook = &models.OrderBook{
+			Symbol: d.Symbol,
+			Bids:   []models.OrderBookLevel{{Price: d.BidPrice, Quantity: d.BidQty}},
+			Asks:   []models.OrderBookLevel{{Price: d.AskPrice, Quantity: d.AskQty}},
+		}
+	}
+	depthes[0].OrderBook.Asks = []models.OrderBookLevel{
+		{Price: 0.0001, Quantity: 2000},
+		{Price: 0.000105, Quantity: 100000},
+	}
+	trader := newTestTrader(testOptions{depthes: depthes, balances: []*models.Balance{
+		{Asset: "BTC", Free: 1.0, Total: 1.0},
+	}})
+
+	seq := trader.bestOfIndexedCycle("BTC", depthes[0].Symbol, 1.0)
+	if seq == nil {
+		t.Fatal("test failed")
+	}
+
+	if seq.Target > 0.25 || seq.Target < 0.15 || seq.Price != 0.0001 {
+		t.Fatalf("test failed %f %f", seq.Target, seq.Price)
+	}
+}
+
+func TestSizeOfSequenceMinNotional(t *testing.T) {
+	depthes := createPricedDepthes([][]interface{}{
+		{"XRP", "BTC", 0.000099, 0.0001},
+		{"XRP", "BNB", 0.0102, 0.0103},
+		{"BNB", "BTC", 0.01, 0.0101},
+	})
+	for _, d := range depthes {
+		d.Symbol.MinNotional = 0.01
+	}
+	trader := newTestTrader(testOptions{depthes: depthes, balances: []*models.Balance{
+		{Asset: "BTC", Free: 0.001, Total: 0.001},
+	}})
+
+	seq := trader.bestOfIndexedCycle("BTC", depthes[0].Symbol, 0.001)
+	if seq != nil {
+		t.Fatal("test failed")
+	}
+}
+
+func TestSizeOfSequenceMatchesOrders(t *testing.T) {
+	depthes := createPricedDepthes([][]interface{}{
+		{"XRP", "BTC", 0.000099, 0.0001},
+		{"XRP", "BNB", 0.0102, 0.0103},
+		{"BNB", "BTC", 0.01, 0.0101},
+	})
+	for _, d := range depthes {
+		d.Symbol.StepSize = 0.01
+		d.OrderBook = &models.OrderBook{
+			Symbol: d.Symbol,
+			Bids:   []models.OrderBookLevel{{Price: d.BidPrice, Quantity: d.BidQty}},
+			Asks:   []models.OrderBookLevel{{Price: d.AskPrice, Quantity: d.AskQty}},
+		}
+	}
+	depthes[0].OrderBook.Asks = []models.OrderBookLevel{
+		{Price: 0.0001, Quantity: 1000},
+		{Price: 0.0001001, Quantity: 100000},
+	}
+	trader := newTestTrader(testOptions{depthes: depthes, balances: []*models.Balance{
+		{Asset: "BTC", Free: 1.0, Total: 1.0},
+	}})
+
+	seq := trader.bestOfIndexedCycle("BTC", depthes[0].Symbol, 1.0)
+	if seq == nil || seq.Price != 0.0001001 {
+		t.Fatal("test failed")
+	}
+
+	// The XRP the buy order gets is what the next leg was sized with.
+	fee := trader.Exchange.GetFee()
+	bought := util.Floor(seq.Target/seq.Price, seq.Symbol.StepSize) * (1 - fee)
+	if math.Abs(seq.Next.Target-bought) > 1e-9 {
+		t.Fatalf("test failed %f %f", seq.Next.Target, bought)
+	}
+
+	target := seq.Target
+	if _, err := trader.simulateSequence(seq, target/2); err != nil || seq.Target != target {
+		t.Fatal("test failed")
+	}
+}
@@ -0,0 +1,210 @@
+package usecase
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/OopsMouse/arbitgo/infrastructure"
+	"github.com/OopsMouse/arbitgo/models"
+)
+
+type testExchange struct {
+	name     string
+	fee      float64
+	symbols  []models.Symbol
+	balances []*models.Balance
+	depthes  map[string]*models.Depth
+	feed     []*models.Depth
+	events   chan *models.OrderEvent
+	sendErr  error
+	accepted map[string]bool
+	canceled []string
+	sending  func(order *models.Order)
+}
+
+func (ex *testExchange) Name() string {
+	return ex.name
+}
+
+func (ex *testExchange) GetFee() float64 {
+	return ex.fee
+}
+
+func (ex *testExchange) GetBalances() ([]*models.Balance, error) {
+	return ex.balances, nil
+}
+
+func (ex *testExchange) GetQuotes() []string {
+	return []string{"BTC", "BNB"}
+}
+
+func (ex *testExchange) GetSymbols() []models.Symbol {
+	return ex.symbols
+}
+
+func (ex *testExchange) GetDepth(symbol models.Symbol) (*models.Depth, error) {
+	depth := ex.depthes[symbol.String()]
+	if depth == nil {
+		return nil, fmt.Errorf("Not found depth for %s", symbol)
+	}
+	return depth, nil
+}
+
+func (ex *testExchange) GetDepthOnUpdate() chan *models.Depth {
+	dch := make(chan *models.Depth)
+	if ex.feed == nil {
+		return dch
+	}
+	go func() {
+		defer close(dch)
+		for _, d := range ex.feed {
+			d.Time = time.Now()
+			dch <- d
+		}
+	}()
+	return dch
+}
+
+func (ex *testExchange) SendOrder(order *models.Order) error {
+	if ex.sending != nil {
+		ex.sending(order)
+	}
+	if ex.accepted != nil {
+		ex.accepted[order.ID] = true
+	}
+	if ex.sendErr != nil {
+		return ex.sendErr
+	}
+	if ex.events != nil {
+		ex.events <- &models.OrderEvent{
+			Status:      models.StatusFilled,
+			Exchange:    ex.name,
+			OrderID:     order.ID,
+			Symbol:      order.Symbol.String(),
+			Side:        order.Side,
+			Price:       order.Price,
+			Quantity:    order.Quantity,
+			ExecutedQty: order.Quantity,
+		}
+	}
+	return nil
+}
+
+func (ex *testExchange) ConfirmOrder(order *models.Order) (float64, error) {
+	return order.Quantity, nil
+}
+
+// QueryOrder finds the orders SendOrder recorded in accepted, which it does
+// even when it fails with sendErr.
+func (ex *testExchange) QueryOrder(order *models.Order) error {
+	if !ex.accepted[order.ID] {
+		return models.NewExchangeError(ex.name, models.ErrUnknownOrder, "Not found order "+order.ID)
+	}
+	return order.Transit(models.StatusNew, time.Now())
+}
+
+func (ex *testExchange) CancelOrder(order *models.Order) error {
+	ex.canceled = append(ex.canceled, order.ID)
+	return nil
+}
+
+func (ex *testExchange) GetOrderEvents() chan *models.OrderEvent {
+	if ex.events == nil {
+		ex.events = make(chan *models.OrderEvent, 16)
+	}
+	return ex.events
+}
+
+// testOptions describe the trader newTestTrader builds. Without venues it
+// trades the depthes on a testExchange holding the balances, or on an
+// ExchangeStub over it when stub is set. parallel trades the legs of the
+// cycle found by parallelSequence at once.
+type testOptions struct {
+	depthes  []*models.Depth
+	balances []*models.Balance
+	stub     bool
+	venues   []testVenue
+	parallel bool
+}
+
+// testVenue is an exchange traded on an ExchangeStub holding the balances.
+type testVenue struct {
+	exchange infrastructure.Exchange
+	balances []*models.Balance
+}
+
+func newTestTrader(opts testOptions) *Trader {
+	if opts.parallel && opts.depthes == nil {
+		opts.depthes = createPricedDepthes([][]interface{}{
+			{"XRP", "BTC", 0.000099, 0.0001},
+			{"XRP", "BNB", 0.0102, 0.0103},
+			{"BNB", "BTC", 0.01, 0.0101},
+		})
+		for _, d := range opts.depthes {
+			d.Symbol.MaxQty = 1000000
+		}
+	}
+
+	venues := opts.venues
+	if venues == nil {
+		ex := &testExchange{
+			name:     "test",
+			fee:      0.001,
+			symbols:  []models.Symbol{},
+			balances: opts.balances,
+			depthes:  map[string]*models.Depth{},
+		}
+		for _, d := range opts.depthes {
+			d.Time = time.Now()
+			if d.OrderBook != nil {
+				d.OrderBook.Time = d.Time
+			}
+			ex.symbols = append(ex.symbols, d.Symbol)
+			ex.depthes[d.Symbol.String()] = d
+		}
+		if !opts.stub {
+			trader := NewTrader(ex, nil)
+			trader.ParallelLegs = opts.parallel
+			cacheDepthes(trader, ex)
+			trader.LoadBalances()
+			return trader
+		}
+		venues = []testVenue{{exchange: ex, balances: opts.balances}}
+	}
+
+	var trader *Trader
+	for _, venue := range venues {
+		balances := map[string]*models.Balance{}
+		for _, b := range venue.balances {
+			balances[b.Asset] = b
+		}
+		stub := infrastructure.NewExchangeStub(venue.exchange, balances)
+		if trader == nil {
+			trader = NewTrader(stub, nil)
+		} else {
+			trader.AddExchange(stub)
+		}
+		cacheDepthes(trader, stub)
+	}
+	trader.ParallelLegs = opts.parallel
+	trader.LoadBalances()
+	return trader
+}
+
+func cacheDepthes(trader *Trader, ex Exchange) {
+	for _, symbol := range ex.GetSymbols() {
+		d, _ := ex.GetDepth(symbol)
+		trader.caches[ex.Name()].Set(d)
+	}
+}
+
+// parallelSequence is the cycle of a parallel test trader, from BTC through
+// its first symbol.
+func parallelSequence(trader *Trader) *models.Sequence {
+	symbol := trader.caches["test"].Get(models.Symbol{Text: "XRPBTC"}).Symbol
+	seq := trader.bestOfIndexedCycle("BTC", symbol, 0.1)
+	for s := seq; s != nil; s = s.Next {
+		s.ID = "parallel"
+	}
+	return seq
+}
@@ -1,6 +1,8 @@
 package usecase
 
 import (
+	"context"
+	"sync/atomic"
 	"time"
 
 	models "github.com/OopsMouse/arbitgo/models"
@@ -9,21 +11,27 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-func (trader *Trader) runTrader() chan *models.Sequence {
+// runTrader runs the sequences received until the context is done. The
+// sequences in flight are counted for the shutdown to wait for.
+func (trader *Trader) runTrader(ctx context.Context) chan *models.Sequence {
 	seqch := make(chan *models.Sequence)
 
 	go func() {
 		for {
 			seq := <-seqch
-			if trader.isRunningPosition(seq.From) {
+			if ctx.Err() != nil || trader.paused() {
+				trader.report.skipped(seq)
 				continue
 			}
+			parallel, ok := trader.planSequence(seq)
+			if !ok {
+				trader.report.skipped(seq)
+				continue
+			}
+			atomic.AddInt64(&trader.inflight, 1)
 			go func() {
-				log.Info("Start trade")
-				defer func() {
-					log.Info("End trade")
-				}()
-				<-trader.doSequence(seq)
+				defer atomic.AddInt64(&trader.inflight, -1)
+				trader.runSequence(seq, parallel)
 			}()
 		}
 	}()
@@ -31,20 +39,82 @@ func (trader *Trader) runTrader() chan *models.Sequence {
 	return seqch
 }
 
-func (trader *Trader) sendOrder(order models.Order) {
+// planSequence begins and reserves the sequence and tells whether its legs run
+// in parallel, which needs inventory in the asset of every leg, or one after
+// another.
+func (trader *Trader) planSequence(seq *models.Sequence) (bool, bool) {
+	if !trader.beginSequence(seq) {
+		return false, false
+	}
+	if trader.ParallelLegs && seq.Next != nil && trader.reserveSequence(seq, true) {
+		return true, true
+	}
+	if !trader.reserveSequence(seq, false) {
+		trader.endSequence()
+		return false, false
+	}
+	return false, true
+}
+
+// runSequence runs a sequence planned with planSequence and ends it. The
+// inventory a parallel run left off balance is restored once it ended.
+func (trader *Trader) runSequence(seq *models.Sequence, parallel bool) {
+	if !parallel {
+		defer trader.endSequence()
+		defer trader.journalBalances(seq.ID)
+		trader.executeSequence(seq)
+		return
+	}
+	orders, statuses := trader.executeParallel(seq)
+	trader.journalBalances(seq.ID)
+	trader.endSequence()
+	trader.restoreInventory(seq, orders, statuses)
+}
+
+func (trader *Trader) executeSequence(seq *models.Sequence) {
+	log.Info("Start trade")
+	defer func() {
+		log.Info("End trade")
+	}()
+	trader.report.executed(seq)
+	<-trader.doSequence(seq)
+}
+
+// sendOrder sends the order and tells whether it failed and, if so, whether
+// the leg counts as failed.
+func (trader *Trader) sendOrder(order *models.Order) (bool, bool) {
 	log.Info("START - send order")
 	log.Info("OrderID : ", order.ID)
 	defer func() {
 		log.Info("END - send order")
 	}()
 
-	util.LogOrder(order)
+	util.LogOrder(*order)
 
-	err := trader.Exchange.SendOrder(&order)
+	// Journaled before it is sent, so that a restart finds the order even
+	// when the process dies before the exchange answers.
+	trader.journalOrder(order)
+	exchange := trader.exchangeOf(order.Exchange)
+	err := exchange.SendOrder(order)
 
+	if err != nil && models.IsRetryable(err) {
+		// The exchange may have accepted the order before the failure.
+		if qerr := exchange.QueryOrder(order); qerr == nil {
+			log.Warn("Order accepted despite the failure : ", order.ID, " : ", err)
+			return false, false
+		}
+	}
 	if err != nil {
-		panic(err)
+		// An order which may have reached the exchange stays open in the
+		// journal, to be looked up after a restart.
+		if !models.IsRetryable(err) {
+			order.Transit(models.StatusRejected, time.Now())
+		}
+		trader.journalOrder(order)
+		return true, trader.onExchangeError("send", order, err)
 	}
+	trader.journalOrder(order)
+	return false, false
 }
 
 type ConfirmStatus string
@@ -55,38 +125,54 @@ const (
 	ALLNG  = ConfirmStatus("ALLNG")
 )
 
-func (trader *Trader) confirmOrder(order models.Order) ConfirmStatus {
+// confirmOrder applies the order's events until it is done. When it is not
+// done within ConfirmTimeout the exchange is asked once.
+func (trader *Trader) confirmOrder(order *models.Order, events chan *models.OrderEvent) (ConfirmStatus, float64) {
 	log.Info("START - confirm order")
 	log.Info("OrderID : ", order.ID)
 	defer func() {
+		trader.unwatchOrder(order)
 		log.Info("END - confirm order")
 	}()
-	for i := 0; i < 12; i++ {
-		executed, err := trader.Exchange.ConfirmOrder(&order)
-		if err != nil {
-			panic(err)
-		}
 
-		if executed > 0 {
-			trader.LoadBalances()
-		}
-
-		log.Infof("[%s] Executed : %f", order.ID, executed)
+	timeout := time.After(trader.ConfirmTimeout)
+	for !order.IsDone() {
+		select {
+		case event := <-events:
+			log.Infof("[%s] %s Executed : %f", order.ID, event.Status, event.ExecutedQty)
+			err := order.Apply(event)
+			if err != nil {
+				log.Warn(err)
+			}
+			trader.journalOrder(order)
+		case <-timeout:
+			executed, err := trader.exchangeOf(order.Exchange).ConfirmOrder(order)
+			if err != nil {
+				trader.onExchangeError("confirm", order, err)
+				return trader.confirmStatusOf(order)
+			}
+			log.Infof("[%s] Executed : %f", order.ID, executed)
 
-		if executed == order.Quantity { // 全部OK
-			return ALLOK
-		} else if executed > 0 { // 部分的にOK
-			return PARTOK
-		} else { // 全部だめ
-			continue
+			if executed > order.ExecutedQty {
+				order.Fill(executed-order.ExecutedQty, order.Price, 0, time.Now())
+			}
+			trader.journalOrder(order)
+			return trader.confirmStatusOf(order)
 		}
+	}
+	return trader.confirmStatusOf(order)
+}
 
-		time.Sleep(5 * time.Second)
+func (trader *Trader) confirmStatusOf(order *models.Order) (ConfirmStatus, float64) {
+	if order.Status == models.StatusFilled { // 全部OK
+		return ALLOK, order.ExecutedQty
+	} else if order.ExecutedQty > 0 { // 部分的にOK
+		return PARTOK, order.ExecutedQty
 	}
-	return ALLNG
+	return ALLNG, 0 // 全部だめ
 }
 
-func checkQuanitiySize(order models.Order) bool {
+func checkQuanitiySize(order *models.Order) bool {
 	if order.Symbol.MaxQty < order.Quantity || order.Symbol.MinQty > order.Quantity {
 		return false
 	}
@@ -98,17 +184,55 @@ func checkQuanitiySize(order models.Order) bool {
 	return true
 }
 
-func (trader *Trader) cancelOrder(order models.Order) {
+// fillOf returns what the order spent of one asset and received of the other
+// at its average price. The commission the exchange charged on the received
+// asset is taken off, or the fee when it reported none.
+func fillOf(order *models.Order, fee float64) (string, float64, string, float64) {
+	price := order.AvgPrice
+	if price <= 0 {
+		price = order.Price
+	}
+	spentAsset, spent, receivedAsset, received := order.Symbol.QuoteAsset, order.ExecutedQty*price, order.Symbol.BaseAsset, order.ExecutedQty
+	if order.Side == models.SideSell {
+		spentAsset, spent, receivedAsset, received = order.Symbol.BaseAsset, order.ExecutedQty, order.Symbol.QuoteAsset, order.ExecutedQty*price
+	}
+	if order.CommissionAsset == "" {
+		received *= 1 - fee
+	} else if order.CommissionAsset == receivedAsset {
+		received -= order.Commission
+	}
+	return spentAsset, spent, receivedAsset, received
+}
+
+// bookOrder converts the leg's reservation by what the order executed,
+// feeding the next leg on the same venue when chained, and releases the rest.
+func (trader *Trader) bookOrder(seq *models.Sequence, order *models.Order, chained bool) {
+	id := legID(seq)
+	spentAsset, spent, asset, received := fillOf(order, trader.exchangeOf(seq.Exchange).GetFee())
+	trader.realize(seq, spentAsset, spent, asset, received)
+
+	nextID := ""
+	if chained && seq.Next != nil && seq.Next.Exchange == seq.Exchange {
+		nextID = legID(seq.Next)
+	}
+
+	trader.ledger.Convert(id, spent, asset, received, nextID)
+	trader.ledger.Release(id)
+}
+
+func (trader *Trader) cancelOrder(order *models.Order) {
 	log.Info("START - cancel order")
 	log.Info("OrderID : ", order.ID)
 	defer func() {
 		log.Info("END - cancel order")
 	}()
 
-	err := trader.Exchange.CancelOrder(&order)
+	err := trader.exchangeOf(order.Exchange).CancelOrder(order)
 	if err != nil {
-		panic(err)
+		trader.onExchangeError("cancel", order, err)
+		return
 	}
+	trader.journalOrder(order)
 }
 
 func (trader *Trader) doSequence(seq *models.Sequence) chan struct{} {
@@ -116,55 +240,110 @@ func (trader *Trader) doSequence(seq *models.Sequence) chan struct{} {
 
 	go func() {
 		defer close(done)
+		chained := false
+		defer func() {
+			if !chained {
+				trader.settle(seq.ID)
+				trader.report.ended(seq.ID)
+			}
+		}()
 
 		trader.PrintSequence(seq)
-		trader.addPosition(seq.From)
-
-		child := []chan struct{}{}
-		order := trader.newOrder(seq)
-
-		if !checkQuanitiySize(order) {
-			return
-		}
 
-		trader.sendOrder(order)
-		status := trader.confirmOrder(order)
-
-		log.Info("Order Result : ", status)
+		order, status, executed := trader.doLeg(seq, true)
+		trader.report.order(*order, status, executed, trader.exchangeOf(seq.Exchange).GetFee())
 
 		switch status {
 		case ALLNG:
+			if !trader.isHomeAsset(seq.From) {
+				trader.recoverAfter(seq, seq.From)
+			}
+			return
 		case PARTOK:
-			trader.cancelOrder(order)
+			// What the leg did not fill is left in its input, which is
+			// unwound once the rest of the sequence is done.
+			if !trader.isHomeAsset(seq.From) {
+				defer trader.recoverAfter(seq, seq.From)
+			}
 		}
 
-		trader.delPosition(seq.From)
-
-		switch status {
-		case ALLNG:
+		if seq.Next == nil {
 			return
 		}
 
-		if seq.Next == nil {
+		if trader.killed() {
+			if !trader.isHomeAsset(seq.Next.From) {
+				trader.recoverAfter(seq, seq.Next.From)
+			}
 			return
 		}
 
-		child = append(child, trader.doSequence(seq.Next))
-
-		defer func() {
-			for _, c := range child {
-				<-c
-			}
-		}()
+		chained = true
+		<-trader.doSequence(seq.Next)
 	}()
 
 	return done
 }
 
-func (trader *Trader) newOrder(seq *models.Sequence) models.Order {
+// doLeg sends the order of one leg and waits for its result. When chained the
+// fill is reserved for the next leg.
+func (trader *Trader) doLeg(seq *models.Sequence, chained bool) (*models.Order, ConfirmStatus, float64) {
+	trader.addPosition(trader.positionOf(seq))
+	defer trader.delPosition(trader.positionOf(seq))
+
+	order := trader.newOrder(seq)
+
+	if !checkQuanitiySize(order) || !trader.checkOrder(order) {
+		trader.ledger.Release(legID(seq))
+		return order, ALLNG, 0
+	}
+
+	trader.ledger.Expect(legID(seq), seq.To)
+	events := trader.watchOrder(order)
+	if failed, counted := trader.sendOrder(order); failed {
+		trader.unwatchOrder(order)
+		trader.ledger.Release(legID(seq))
+		if counted {
+			trader.legResult(ALLNG)
+		}
+		return order, ALLNG, 0
+	}
+	status, executed := trader.confirmOrder(order, events)
+	if !order.IsDone() {
+		// Whatever it executed so far, an order left on the book would
+		// outlive its reservation. It is booked as it ends.
+		trader.cancelOrder(order)
+		trader.settleOrder(order)
+		status, executed = trader.confirmStatusOf(order)
+	}
+	trader.legResult(status)
+
+	log.Info("Order Result : ", status)
+	trader.bookOrder(seq, order, chained)
+
+	return order, status, executed
+}
+
+// settleOrder looks up the final state of a canceled order, which may have
+// executed more before the cancel took.
+func (trader *Trader) settleOrder(order *models.Order) {
+	if order.IsDone() {
+		return
+	}
+	if err := trader.exchangeOf(order.Exchange).QueryOrder(order); err != nil {
+		trader.onExchangeError("query", order, err)
+		return
+	}
+	trader.journalOrder(order)
+}
+
+func (trader *Trader) newOrder(seq *models.Sequence) *models.Order {
 	trader.LoadBalances()
 	trader.PrintBalanceOfBigAssets()
-	balance := trader.GetBalance(seq.From).Free
+	balance := trader.reserveLeg(seq)
+	if seq.Target > 0 && seq.Target < balance {
+		balance = seq.Target
+	}
 	var quantity float64
 	if seq.Side == models.SideBuy {
 		quantity = util.Floor(balance/seq.Price, seq.Symbol.StepSize)
@@ -172,14 +351,8 @@ func (trader *Trader) newOrder(seq *models.Sequence) models.Order {
 		quantity = util.Floor(balance, seq.Symbol.StepSize)
 	}
 
-	order := models.Order{
-		ID:        xid.New().String(),
-		Symbol:    seq.Symbol,
-		OrderType: models.TypeLimit,
-		Price:     seq.Price,
-		Side:      seq.Side,
-		Quantity:  quantity,
-		Sequence:  seq,
-	}
+	order := models.NewOrder(xid.New().String(), seq.Exchange, seq.Symbol, models.TypeLimit, seq.Side, seq.Price, quantity)
+	order.TimeInForce = trader.TimeInForce
+	order.Sequence = seq
 	return order
 }
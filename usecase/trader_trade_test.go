@@ -0,0 +1,172 @@
+package usecase
+
+import (
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/OopsMouse/arbitgo/infrastructure"
+	"github.com/OopsMouse/arbitgo/models"
+)
+
+func TestDoLegCancelsRestingOrder(t *testing.T) {
+	for _, askQty := range []float64{0, 40} {
+		depthes := createPricedDepthes([][]interface{}{
+			{"XRP", "BTC", 0.000099, 0.0001},
+		})
+		depthes[0].AskQty = askQty
+		depthes[0].Symbol.StepSize = 1
+		depthes[0].Symbol.MaxQty = 1000000
+		trader := newTestTrader(testOptions{depthes: depthes, stub: true, balances: []*models.Balance{
+			{Asset: "BTC", Free: 1.0, Total: 1.0},
+		}})
+		stub := trader.Exchange.(infrastructure.ExchangeStub)
+		trader.TimeInForce = models.GTC
+		trader.ConfirmTimeout = 300 * time.Millisecond
+
+		seq := &models.Sequence{
+			ID:       "rest",
+			Exchange: "test",
+			Symbol:   depthes[0].Symbol,
+			Side:     models.SideBuy,
+			From:     "BTC",
+			To:       "XRP",
+			Price:    0.0001,
+			Target:   0.01,
+		}
+		order, status, executed := trader.doLeg(seq, false)
+
+		if len(stub.ExecutingOrders) != 0 || order.Status != models.StatusCanceled || executed != askQty {
+			t.Fatalf("test failed %s %f", order.Status, executed)
+		}
+		if (askQty == 0 && status != ALLNG) || (askQty > 0 && status != PARTOK) {
+			t.Fatal("test failed")
+		}
+		if trader.ledger.Reserved(legID(seq)) != 0 {
+			t.Fatal("test failed")
+		}
+	}
+}
+
+func TestBookOrderMatchesExchange(t *testing.T) {
+	depthes := createPricedDepthes([][]interface{}{
+		{"XRP", "BTC", 0.000099, 0.0001},
+	})
+	depthes[0].AskQty = 40
+	depthes[0].Symbol.StepSize = 1
+	depthes[0].Symbol.MaxQty = 1000000
+	trader := newTestTrader(testOptions{depthes: depthes, stub: true, balances: []*models.Balance{
+		{Asset: "BTC", Free: 1.0, Total: 1.0},
+	}})
+	stub := trader.Exchange.(infrastructure.ExchangeStub)
+	trader.TimeInForce = models.GTC
+	trader.ConfirmTimeout = 300 * time.Millisecond
+
+	seq := &models.Sequence{
+		ID:       "book",
+		Exchange: "test",
+		Symbol:   depthes[0].Symbol,
+		Side:     models.SideBuy,
+		From:     "BTC",
+		To:       "XRP",
+		Price:    0.0001,
+		Target:   0.01,
+	}
+	if _, status, _ := trader.doLeg(seq, false); status != PARTOK {
+		t.Fatal("test failed")
+	}
+
+	for _, asset := range []string{"BTC", "XRP"} {
+		b, _ := stub.GetBalance(asset)
+		l := trader.ledger.Balance("test", asset)
+		if b == nil || l == nil || math.Abs(b.Free-l.Free) > 1e-9 || math.Abs(b.Total-l.Total) > 1e-9 {
+			t.Fatalf("test failed %s", asset)
+		}
+	}
+}
+
+func TestReconcileWhileOrdersRest(t *testing.T) {
+	for _, askQty := range []float64{0, 40} {
+		depthes := createPricedDepthes([][]interface{}{
+			{"XRP", "BTC", 0.000099, 0.0001},
+		})
+		depthes[0].AskQty = askQty
+		depthes[0].Symbol.StepSize = 1
+		depthes[0].Symbol.MaxQty = 1000000
+		trader := newTestTrader(testOptions{depthes: depthes, stub: true, balances: []*models.Balance{
+			{Asset: "BTC", Free: 1.0, Total: 1.0},
+		}})
+		stub := trader.Exchange.(infrastructure.ExchangeStub)
+		trader.TimeInForce = models.GTC
+		trader.ConfirmTimeout = 300 * time.Millisecond
+
+		stop := make(chan struct{})
+		reconciled := make(chan struct{})
+		go func() {
+			defer close(reconciled)
+			for {
+				select {
+				case <-stop:
+					return
+				case <-time.After(10 * time.Millisecond):
+					trader.LoadBalances()
+				}
+			}
+		}()
+
+		wg := &sync.WaitGroup{}
+		for _, id := range []string{"a", "b"} {
+			seq := &models.Sequence{
+				ID:       id,
+				Exchange: "test",
+				Symbol:   depthes[0].Symbol,
+				Side:     models.SideBuy,
+				From:     "BTC",
+				To:       "XRP",
+				Price:    0.0001,
+				Target:   0.4,
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				trader.doLeg(seq, false)
+			}()
+		}
+
+		// Both orders rest and lock their BTC on the exchange, which must not
+		// be taken off the balance a second time.
+		deadline := time.Now().Add(200 * time.Millisecond)
+		for {
+			if b, _ := stub.GetBalance("BTC"); b.Free < 0.2+1e-9 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("test failed")
+			}
+			time.Sleep(time.Millisecond)
+		}
+		time.Sleep(50 * time.Millisecond)
+		if available := trader.GetAvailable("test", "BTC"); math.Abs(available-0.2) > 1e-9 {
+			t.Fatalf("test failed %f", available)
+		}
+
+		wg.Wait()
+		close(stop)
+		<-reconciled
+
+		for _, asset := range []string{"BTC", "XRP"} {
+			b, _ := stub.GetBalance(asset)
+			l := trader.ledger.Balance("test", asset)
+			if askQty == 0 && asset == "XRP" {
+				continue
+			}
+			if b == nil || l == nil || math.Abs(b.Total-l.Total) > 1e-9 {
+				t.Fatalf("test failed %s", asset)
+			}
+		}
+		if available := trader.GetAvailable("test", "BTC"); math.Abs(available-trader.GetBalance("BTC").Total) > 1e-9 {
+			t.Fatal("test failed")
+		}
+	}
+}
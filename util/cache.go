@@ -7,45 +7,212 @@ import (
 	"github.com/OopsMouse/arbitgo/models"
 )
 
+const DefaultDepthMaxAge = 1 * time.Minute
+
+// DepthCacheStats counts what the cache received and what it refused to
+// serve. Latency is between the event time of the exchange and the receipt.
+type DepthCacheStats struct {
+	Updates        int64         `json:"updates"`
+	OutOfOrder     int64         `json:"out_of_order"`
+	Crossed        int64         `json:"crossed"`
+	Locked         int64         `json:"locked"`
+	StaleRejects   int64         `json:"stale_rejects"`
+	SuspectRejects int64         `json:"suspect_rejects"`
+	LatencyAvg     time.Duration `json:"latency_avg"`
+	LatencyMax     time.Duration `json:"latency_max"`
+	latencySum     time.Duration
+	latencyCount   int64
+}
+
 type DepthCache struct {
-	cache      map[string]*models.Depth
-	lock       *sync.Mutex
-	expireTime time.Duration
+	cache   map[string]*models.Depth
+	books   map[string]*models.OrderBook
+	suspect map[string]bool
+	counted map[string]bool
+	maxAges map[string]time.Duration
+	maxAge  time.Duration
+	now     func() time.Time
+	stats   DepthCacheStats
+	lock    *sync.Mutex
 }
 
 func NewDepthCache() *DepthCache {
 	d := &DepthCache{
-		cache:      map[string]*models.Depth{},
-		lock:       new(sync.Mutex),
-		expireTime: 1 * time.Minute,
+		cache:   map[string]*models.Depth{},
+		books:   map[string]*models.OrderBook{},
+		suspect: map[string]bool{},
+		counted: map[string]bool{},
+		maxAges: map[string]time.Duration{},
+		maxAge:  DefaultDepthMaxAge,
+		now:     time.Now,
+		lock:    new(sync.Mutex),
 	}
 	return d
 }
 
-func (c *DepthCache) Set(depth *models.Depth) {
+// SetMaxAge sets how old a depth may get before it is stale, for every symbol
+// without a threshold of its own.
+func (c *DepthCache) SetMaxAge(maxAge time.Duration) {
+	defer c.lock.Unlock()
+	c.lock.Lock()
+	c.maxAge = maxAge
+}
+
+// SetClock sets what depthes are aged against, such as the recorded time of a
+// replay.
+func (c *DepthCache) SetClock(now func() time.Time) {
 	defer c.lock.Unlock()
 	c.lock.Lock()
-	c.cache[depth.Symbol.String()] = depth
+	c.now = now
+}
+
+// SetSymbolMaxAge sets how old a depth of the symbol may get.
+func (c *DepthCache) SetSymbolMaxAge(symbol string, maxAge time.Duration) {
+	defer c.lock.Unlock()
+	c.lock.Lock()
+	c.maxAges[symbol] = maxAge
+}
+
+func (c *DepthCache) maxAgeOf(symbol string) time.Duration {
+	if maxAge, ok := c.maxAges[symbol]; ok {
+		return maxAge
+	}
+	return c.maxAge
 }
 
+// updatedAt returns when the exchange last updated the depth, or when it was
+// received when the exchange does not tell. It only orders the depthes of a
+// symbol, which are aged from their receipt since the clock of the exchange
+// is not ours.
+func updatedAt(depth *models.Depth) time.Time {
+	if !depth.EventTime.IsZero() {
+		return depth.EventTime
+	}
+	return depth.Time
+}
+
+// olderThan tells whether the depth is older than the cached one, by update
+// ID when both have one. Depthes of the REST API have none and are ordered
+// by time instead.
+func olderThan(depth *models.Depth, old *models.Depth) bool {
+	if depth.UpdateID > 0 && old.UpdateID > 0 {
+		return depth.UpdateID < old.UpdateID
+	}
+	return updatedAt(depth).Before(updatedAt(old))
+}
+
+// Set caches the depth unless it is older than the cached one, and tells
+// whether it did. A crossed or locked depth is cached as suspect and not
+// served until a sound one comes.
+func (c *DepthCache) Set(depth *models.Depth) bool {
+	defer c.lock.Unlock()
+	c.lock.Lock()
+
+	key := depth.Symbol.String()
+	if old := c.cache[key]; old != nil && olderThan(depth, old) {
+		c.stats.OutOfOrder++
+		return false
+	}
+
+	c.stats.Updates++
+	if !depth.EventTime.IsZero() {
+		latency := depth.Time.Sub(depth.EventTime)
+		c.stats.latencySum += latency
+		c.stats.latencyCount++
+		if latency > c.stats.LatencyMax {
+			c.stats.LatencyMax = latency
+		}
+	}
+
+	suspect := false
+	if depth.BidPrice > 0 && depth.AskPrice > 0 {
+		if depth.BidPrice > depth.AskPrice {
+			c.stats.Crossed++
+			suspect = true
+		} else if depth.BidPrice == depth.AskPrice {
+			c.stats.Locked++
+			suspect = true
+		}
+	}
+
+	c.cache[key] = depth
+	c.suspect[key] = suspect
+	c.counted[key] = false
+	if depth.OrderBook != nil {
+		c.books[key] = depth.OrderBook
+	}
+	return true
+}
+
+// usable tells whether the depth is fresh and sound, aged from its receipt.
+// A refused depth is counted once when count is set. The caller holds lock.
+func (c *DepthCache) usable(key string, depth *models.Depth, count bool) bool {
+	if c.suspect[key] {
+		if count && !c.counted[key] {
+			c.stats.SuspectRejects++
+			c.counted[key] = true
+		}
+		return false
+	}
+	if c.now().Sub(depth.Time) >= c.maxAgeOf(key) {
+		if count && !c.counted[key] {
+			c.stats.StaleRejects++
+			c.counted[key] = true
+		}
+		return false
+	}
+	return true
+}
+
+// Get returns the depth of the symbol unless it is stale or suspect. A depth
+// refused here is counted, since the caller was about to trade on it, but
+// only once however many cycles look it up.
 func (c *DepthCache) Get(symbol models.Symbol) *models.Depth {
 	defer c.lock.Unlock()
 	c.lock.Lock()
-	depth := c.cache[symbol.String()]
-	if time.Now().Sub(depth.Time) < c.expireTime {
-		return depth
+	key := symbol.String()
+	depth := c.cache[key]
+	if depth == nil || !c.usable(key, depth, true) {
+		return nil
 	}
-	return nil
+	return depth
 }
 
+// GetAll returns every usable depth. The ones left out are not counted as
+// rejects, since most of them would not have been traded anyway.
 func (c *DepthCache) GetAll() []*models.Depth {
 	defer c.lock.Unlock()
 	c.lock.Lock()
 	depthList := []*models.Depth{}
-	for _, v := range c.cache {
-		if time.Now().Sub(v.Time) < c.expireTime {
+	for k, v := range c.cache {
+		if c.usable(k, v, false) {
 			depthList = append(depthList, v)
 		}
 	}
 	return depthList
 }
+
+func (c *DepthCache) GetOrderBook(symbol models.Symbol) *models.OrderBook {
+	defer c.lock.Unlock()
+	c.lock.Lock()
+	key := symbol.String()
+	book := c.books[key]
+	if book == nil || c.suspect[key] {
+		return nil
+	}
+	if c.now().Sub(book.Time) < c.maxAgeOf(key) {
+		return book
+	}
+	return nil
+}
+
+// Stats returns a copy of the counters.
+func (c *DepthCache) Stats() DepthCacheStats {
+	defer c.lock.Unlock()
+	c.lock.Lock()
+	stats := c.stats
+	if stats.latencyCount > 0 {
+		stats.LatencyAvg = stats.latencySum / time.Duration(stats.latencyCount)
+	}
+	return stats
+}
@@ -0,0 +1,141 @@
+package util
+
+import (
+	"testing"
+	"time"
+
+	"github.com/OopsMouse/arbitgo/models"
+)
+
+func newTestDepth(symbol string, bid float64, ask float64) *models.Depth {
+	return &models.Depth{
+		Symbol:   models.Symbol{Text: symbol},
+		BidPrice: bid,
+		AskPrice: ask,
+		Time:     time.Now(),
+	}
+}
+
+func TestDepthCacheStale(t *testing.T) {
+	cache := NewDepthCache()
+	cache.SetSymbolMaxAge("ETHBTC", time.Second)
+
+	eth := newTestDepth("ETHBTC", 0.09, 0.091)
+	eth.Time = time.Now().Add(-2 * time.Second)
+	cache.Set(eth)
+	xrp := newTestDepth("XRPBTC", 0.0001, 0.00011)
+	xrp.Time = eth.Time
+	cache.Set(xrp)
+
+	if cache.Get(eth.Symbol) != nil || cache.Get(xrp.Symbol) == nil {
+		t.Fatal("test failed")
+	}
+	if cache.Get(models.Symbol{Text: "BNBBTC"}) != nil {
+		t.Fatal("test failed")
+	}
+	if len(cache.GetAll()) != 1 || cache.Stats().StaleRejects != 1 {
+		t.Fatal("test failed")
+	}
+}
+
+func TestDepthCacheSuspect(t *testing.T) {
+	cache := NewDepthCache()
+	symbol := models.Symbol{Text: "ETHBTC"}
+
+	cache.Set(newTestDepth("ETHBTC", 0.092, 0.091))
+	if cache.Get(symbol) != nil {
+		t.Fatal("test failed")
+	}
+	cache.Set(newTestDepth("ETHBTC", 0.091, 0.091))
+	if cache.Get(symbol) != nil {
+		t.Fatal("test failed")
+	}
+	cache.Set(newTestDepth("ETHBTC", 0.09, 0.091))
+	if cache.Get(symbol) == nil {
+		t.Fatal("test failed")
+	}
+
+	stats := cache.Stats()
+	if stats.Crossed != 1 || stats.Locked != 1 || stats.SuspectRejects != 2 {
+		t.Fatal("test failed")
+	}
+}
+
+func TestDepthCacheSequence(t *testing.T) {
+	cache := NewDepthCache()
+
+	newer := newTestDepth("ETHBTC", 0.09, 0.091)
+	newer.UpdateID = 10
+	newer.EventTime = newer.Time.Add(-50 * time.Millisecond)
+	cache.Set(newer)
+
+	older := newTestDepth("ETHBTC", 0.08, 0.081)
+	older.UpdateID = 9
+	cache.Set(older)
+
+	if cache.Get(newer.Symbol) != newer {
+		t.Fatal("test failed")
+	}
+	stats := cache.Stats()
+	if stats.OutOfOrder != 1 || stats.Updates != 1 || stats.LatencyAvg != 50*time.Millisecond {
+		t.Fatal("test failed")
+	}
+}
+
+func TestDepthCacheEventTime(t *testing.T) {
+	cache := NewDepthCache()
+	cache.SetMaxAge(time.Second)
+
+	// The clock of the exchange may be off, a depth is aged from its receipt.
+	eth := newTestDepth("ETHBTC", 0.09, 0.091)
+	eth.EventTime = eth.Time.Add(-2 * time.Second)
+	cache.Set(eth)
+	if cache.Get(eth.Symbol) != eth {
+		t.Fatal("test failed")
+	}
+}
+
+func TestDepthCacheCountsOnce(t *testing.T) {
+	cache := NewDepthCache()
+	symbol := models.Symbol{Text: "ETHBTC"}
+
+	cache.Set(newTestDepth("ETHBTC", 0.092, 0.091))
+	for i := 0; i < 3; i++ {
+		cache.Get(symbol)
+	}
+	if cache.Stats().SuspectRejects != 1 {
+		t.Fatal("test failed")
+	}
+
+	cache.SetMaxAge(time.Second)
+	stale := newTestDepth("XRPBTC", 0.0001, 0.00011)
+	stale.Time = time.Now().Add(-2 * time.Second)
+	if !cache.Set(stale) {
+		t.Fatal("test failed")
+	}
+	cache.Get(stale.Symbol)
+	cache.Get(stale.Symbol)
+	if cache.Stats().StaleRejects != 1 {
+		t.Fatal("test failed")
+	}
+
+	older := newTestDepth("XRPBTC", 0.0001, 0.00011)
+	older.Time = stale.Time.Add(-time.Second)
+	if cache.Set(older) {
+		t.Fatal("test failed")
+	}
+}
+
+func TestDepthCacheSequenceByTime(t *testing.T) {
+	cache := NewDepthCache()
+
+	newer := newTestDepth("ETHBTC", 0.09, 0.091)
+	cache.Set(newer)
+	older := newTestDepth("ETHBTC", 0.08, 0.081)
+	older.Time = newer.Time.Add(-time.Second)
+	cache.Set(older)
+
+	if cache.Get(newer.Symbol) != newer || cache.Stats().OutOfOrder != 1 {
+		t.Fatal("test failed")
+	}
+}
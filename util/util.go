@@ -82,16 +82,19 @@ func (s *Set) Append(i string) {
 func (s *Set) Remove(i string) {
 	defer s.lock.Unlock()
 	s.lock.Lock()
-	if s.Include(i) {
-		delete(s.buff, i)
-	}
+	delete(s.buff, i)
 }
 
 func (s *Set) Include(i string) bool {
-	return Include(s.ToSlice(), i)
+	defer s.lock.Unlock()
+	s.lock.Lock()
+	_, ok := s.buff[i]
+	return ok
 }
 
 func (s *Set) ToSlice() []string {
+	defer s.lock.Unlock()
+	s.lock.Lock()
 	keys := make([]string, 0, len(s.buff))
 	for k := range s.buff {
 		keys = append(keys, k)
@@ -111,6 +114,9 @@ func GetCurrentDir() string {
 
 type Operation func() error
 
+// BackoffRetry retries the operation with a growing delay as long as the
+// error is retryable. Retrying a refused request would only be refused again,
+// or extend a ban.
 func BackoffRetry(retry int, op Operation) error {
 	b := &backoff.Backoff{
 		Max: 5 * time.Minute,
@@ -121,6 +127,9 @@ func BackoffRetry(retry int, op Operation) error {
 		if err == nil {
 			return nil
 		}
+		if !models.IsRetryable(err) {
+			return err
+		}
 		d := b.Duration()
 		time.Sleep(d)
 	}
@@ -128,7 +137,17 @@ func BackoffRetry(retry int, op Operation) error {
 }
 
 func Floor(a float64, b float64) float64 {
-	return float64(math.Trunc(a/b)) * b
+	if b <= 0 {
+		return a
+	}
+	return float64(math.Trunc(a/b+1e-9)) * b
+}
+
+func Ceil(a float64, b float64) float64 {
+	if b <= 0 {
+		return a
+	}
+	return float64(math.Ceil(a/b-1e-9)) * b
 }
 
 func LogOrder(order models.Order) {
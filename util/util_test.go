@@ -12,3 +12,9 @@ func TestFloor(t *testing.T) {
 		t.Fatal("failed test")
 	}
 }
+
+func TestCeil(t *testing.T) {
+	if Ceil(10.46405786, 0.01) != 10.47 || Ceil(10.46, 0.01) != 10.46 {
+		t.Fatal("failed test")
+	}
+}